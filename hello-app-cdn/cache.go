@@ -0,0 +1,188 @@
+/**
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cacheRule is the cache policy of the paths matching Path. A path ending
+// with a slash matches every path under it, like a http.ServeMux pattern;
+// other paths only match themselves.
+type cacheRule struct {
+	Path string `yaml:"path"`
+
+	// MaxAge and SMaxAge are pointers so that a zero age, which asks caches
+	// to revalidate every request, can be told apart from an unset one.
+	MaxAge               *time.Duration `yaml:"maxAge"`
+	SMaxAge              *time.Duration `yaml:"sMaxAge"`
+	StaleWhileRevalidate time.Duration  `yaml:"staleWhileRevalidate"`
+	StaleIfError         time.Duration  `yaml:"staleIfError"`
+	NoStore              bool           `yaml:"noStore"`
+	Private              bool           `yaml:"private"`
+
+	// Tags are the cache tags of the responses, besides the cacheTags setting.
+	Tags []string `yaml:"tags"`
+}
+
+// cachePolicyFile is the format of the file named by the cacheRules setting.
+type cachePolicyFile struct {
+	Rules []cacheRule `yaml:"rules"`
+}
+
+// cachePolicy selects the Cache-Control header of a response by request path.
+type cachePolicy struct {
+	rules []cacheRule
+	// def is sent when no rule matches.
+	def string
+	// globalTags are the cache tags of every response.
+	globalTags []string
+}
+
+// loadCachePolicy reads the rules from the YAML file at path, if path is not
+// empty. def is the Cache-Control header of the paths no rule matches, and
+// tags are the cache tags of every path.
+func loadCachePolicy(path, def string, tags []string) (*cachePolicy, error) {
+	p := &cachePolicy{def: def, globalTags: tags}
+	if path == "" {
+		return p, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading cache rules: %v", err)
+	}
+	defer f.Close()
+	var file cachePolicyFile
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing cache rules %s: %v", path, err)
+	}
+	seen := make(map[string]bool)
+	for i, rule := range file.Rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("cache rule %d (%s): %v", i+1, rule.Path, err)
+		}
+		if seen[rule.Path] {
+			return nil, fmt.Errorf("cache rule %d: duplicate path %s", i+1, rule.Path)
+		}
+		seen[rule.Path] = true
+	}
+	p.rules = file.Rules
+	return p, nil
+}
+
+func (r cacheRule) validate() error {
+	if !strings.HasPrefix(r.Path, "/") {
+		return errors.New("path must start with /")
+	}
+	for _, d := range []*time.Duration{r.MaxAge, r.SMaxAge, &r.StaleWhileRevalidate, &r.StaleIfError} {
+		if d != nil && *d < 0 {
+			return errors.New("ages must not be negative")
+		}
+	}
+	if r.NoStore && (r.MaxAge != nil || r.SMaxAge != nil || r.StaleWhileRevalidate != 0 || r.StaleIfError != 0) {
+		return errors.New("noStore cannot be combined with ages")
+	}
+	if r.Private && r.SMaxAge != nil {
+		return errors.New("sMaxAge only applies to shared caches and cannot be combined with private")
+	}
+	return validateTags(r.Tags)
+}
+
+// validateTags checks that tags can be listed in a header.
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if tag == "" || strings.ContainsAny(tag, ", \t") {
+			return fmt.Errorf("invalid cache tag %q", tag)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to path.
+func (r cacheRule) matches(path string) bool {
+	if strings.HasSuffix(r.Path, "/") {
+		return strings.HasPrefix(path, r.Path)
+	}
+	return path == r.Path
+}
+
+// header returns the Cache-Control header value of the rule.
+func (r cacheRule) header() string {
+	var directives []string
+	if r.NoStore {
+		directives = append(directives, "no-store")
+	}
+	if r.Private {
+		directives = append(directives, "private")
+	} else if !r.NoStore {
+		directives = append(directives, "public")
+	}
+	seconds := func(name string, d time.Duration) {
+		directives = append(directives, name+"="+strconv.FormatInt(int64(d/time.Second), 10))
+	}
+	if r.MaxAge != nil {
+		seconds("max-age", *r.MaxAge)
+	}
+	if r.SMaxAge != nil {
+		seconds("s-maxage", *r.SMaxAge)
+	}
+	if r.StaleWhileRevalidate > 0 {
+		seconds("stale-while-revalidate", r.StaleWhileRevalidate)
+	}
+	if r.StaleIfError > 0 {
+		seconds("stale-if-error", r.StaleIfError)
+	}
+	return strings.Join(directives, ", ")
+}
+
+// rule returns the rule with the longest path matching path, or nil.
+func (p *cachePolicy) rule(path string) *cacheRule {
+	var best *cacheRule
+	for i, r := range p.rules {
+		if r.matches(path) && (best == nil || len(r.Path) > len(best.Path)) {
+			best = &p.rules[i]
+		}
+	}
+	return best
+}
+
+// tags returns the cache tags of the response to a request to path.
+func (p *cachePolicy) tags(path string) []string {
+	tags := p.globalTags
+	if r := p.rule(path); r != nil && len(r.Tags) > 0 {
+		tags = append(append([]string(nil), tags...), r.Tags...)
+	}
+	return tags
+}
+
+// header returns the Cache-Control header value for a request to path.
+func (p *cachePolicy) header(path string) string {
+	if r := p.rule(path); r != nil {
+		return r.header()
+	}
+	return p.def
+}
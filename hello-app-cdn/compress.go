@@ -0,0 +1,139 @@
+/**
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// codings lists the content codings the server can compress responses with,
+// in order of preference.
+var codings = []string{"br", "gzip"}
+
+// compressibleTypes are the media types, besides text/*, worth compressing.
+var compressibleTypes = map[string]bool{
+	"application/javascript":    true,
+	"application/json":          true,
+	"application/manifest+json": true,
+	"application/xml":           true,
+	"image/svg+xml":             true,
+}
+
+// encodeBody returns body in the content coding negotiated with the client,
+// and sets the Content-Encoding and Vary headers accordingly. variants holds
+// pre-compressed versions of body by content coding, which are used instead
+// of compressing body. With compression disabled, only variants are used.
+func encodeBody(w http.ResponseWriter, r *http.Request, body []byte, variants map[string][]byte) []byte {
+	var available []string
+	for _, coding := range codings {
+		if _, ok := variants[coding]; ok || cfg.Compression && compressible(w.Header().Get("Content-Type")) {
+			available = append(available, coding)
+		}
+	}
+	if len(available) == 0 {
+		return body
+	}
+	// the response depends on Accept-Encoding, so caches must key on it
+	addVary(w.Header(), "Accept-Encoding")
+	coding := negotiateEncoding(r.Header.Get("Accept-Encoding"), available)
+	if coding == "" {
+		return body
+	}
+	encoded, ok := variants[coding]
+	if !ok {
+		var err error
+		if encoded, err = compress(body, coding); err != nil {
+			return body
+		}
+	}
+	w.Header().Set("Content-Encoding", coding)
+	return encoded
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+func compress(body []byte, coding string) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch coding {
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		w = gzip.NewWriter(&buf)
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// addVary adds name to the Vary header unless it is already listed.
+func addVary(h http.Header, name string) {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+// negotiateEncoding returns the first of the available content codings that
+// the Accept-Encoding header accepts, or "" for the identity encoding.
+func negotiateEncoding(acceptEncoding string, available []string) string {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				q = f
+			}
+		}
+		if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" {
+			accepted[coding] = q
+		}
+	}
+	for _, coding := range available {
+		q, ok := accepted[coding]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > 0 {
+			return coding
+		}
+	}
+	return ""
+}
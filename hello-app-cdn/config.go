@@ -0,0 +1,338 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// This file is shared by hello-app, hello-app-tls, hello-app-cdn,
+// hello-app-redis and quickstart/go so that they are configured the same way.
+// Each sample is built from its own directory, so the copies must stay
+// identical: .github/workflows/hello-app-config-ci.yml checks that they are,
+// and runs the tests in hello-app/config_test.go.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfig fills cfg, a pointer to a struct holding the default settings,
+// from the following sources in increasing order of precedence:
+//
+//  1. a YAML file named by the -config flag or the CONFIG_FILE environment
+//     variable, if any
+//  2. environment variables that are set and not empty
+//  3. command-line flags
+//
+// Every exported field of cfg is a setting, described by its struct tags:
+//
+//	yaml:"metricsPort"    key in the YAML file; the flag is named after it (-metrics-port)
+//	flag:"metrics-port"   name of the flag, if it cannot be derived from the key
+//	env:"METRICS_PORT"    environment variable, if the setting has one
+//	usage:"..."           help text of the flag
+//	secret:"true"         redacted by -print-config
+//
+// Settings can be strings, booleans, integers, floats, durations or string
+// lists, which are comma-separated in environment variables and flags.
+//
+// If cfg has a validate method, it is called once all sources are applied.
+// With -print-config, the resulting settings are written to stdout as YAML
+// and the process exits.
+func loadConfig(cfg interface{}) error {
+	printed, err := parseConfig(flag.CommandLine, os.Args[1:], os.Stdout, cfg)
+	if err == nil && printed {
+		os.Exit(0)
+	}
+	return err
+}
+
+// parseConfig implements loadConfig with the flags defined in fs and parsed
+// from args. With -print-config, it writes the settings to w and returns
+// true.
+func parseConfig(fs *flag.FlagSet, args []string, w io.Writer, cfg interface{}) (bool, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return false, fmt.Errorf("config must be a pointer to a struct, got %T", cfg)
+	}
+	settings, err := describeSettings(v.Elem())
+	if err != nil {
+		return false, err
+	}
+
+	configFile := fs.String("config", "", "path of a YAML configuration file (env CONFIG_FILE)")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration and exit")
+	for _, s := range settings {
+		// Zero defaults are left out so that the help text does not show them.
+		def := ""
+		if !s.field.IsZero() {
+			def = s.format()
+		}
+		fs.Var(&rawValue{s: def, isBool: s.field.Kind() == reflect.Bool}, s.flag, s.usage())
+	}
+	if err := fs.Parse(args); err != nil {
+		return false, err
+	}
+
+	if *configFile == "" {
+		*configFile = os.Getenv("CONFIG_FILE")
+	}
+	if *configFile != "" {
+		if err := readConfigFile(*configFile, cfg); err != nil {
+			return false, err
+		}
+	}
+
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		if raw := os.Getenv(s.env); raw != "" {
+			if err := s.set(raw); err != nil {
+				return false, fmt.Errorf("environment variable %s: %v", s.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				if err := s.set(f.Value.String()); err != nil {
+					flagErr = fmt.Errorf("flag -%s: %v", s.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return false, flagErr
+	}
+
+	if val, ok := cfg.(interface{ validate() error }); ok {
+		if err := val.validate(); err != nil {
+			return false, fmt.Errorf("invalid configuration: %v", err)
+		}
+	}
+
+	if *printConfig {
+		out, err := yaml.Marshal(redacted(v.Elem(), settings))
+		if err != nil {
+			return false, err
+		}
+		_, err = w.Write(out)
+		return true, err
+	}
+	return false, nil
+}
+
+func readConfigFile(path string, cfg interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading configuration file: %v", err)
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing configuration file %s: %v", path, err)
+	}
+	return nil
+}
+
+// setting is a single field of a configuration struct.
+type setting struct {
+	index  int
+	field  reflect.Value
+	flag   string
+	env    string
+	help   string
+	secret bool
+}
+
+func describeSettings(v reflect.Value) ([]setting, error) {
+	var settings []setting
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			return nil, fmt.Errorf("config field %s has no yaml key", f.Name)
+		}
+		s := setting{
+			index:  i,
+			field:  v.Field(i),
+			flag:   flagName(name),
+			env:    f.Tag.Get("env"),
+			help:   f.Tag.Get("usage"),
+			secret: f.Tag.Get("secret") == "true",
+		}
+		if name := f.Tag.Get("flag"); name != "" {
+			s.flag = name
+		}
+		if !supportedType(f.Type) {
+			return nil, fmt.Errorf("config field %s has unsupported type %s", f.Name, f.Type)
+		}
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+// flagName turns a YAML key such as "maxCPUDuration" into "max-cpu-duration".
+// A plural acronym such as "SANs" is kept together.
+func flagName(key string) string {
+	runes := []rune(key)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			plural := i+1 < len(runes) && runes[i+1] == 's' && (i+2 == len(runes) || unicode.IsUpper(runes[i+2]))
+			if !unicode.IsUpper(prev) || nextLower && !plural {
+				b.WriteByte('-')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+func (s setting) usage() string {
+	if s.env == "" {
+		return s.help
+	}
+	return fmt.Sprintf("%s (env %s)", s.help, s.env)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func supportedType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// set parses raw according to the type of the setting and stores it.
+func (s setting) set(raw string) error {
+	f := s.field
+	switch {
+	case f.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+	case f.Kind() == reflect.String:
+		f.SetString(raw)
+	case f.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case f.Kind() >= reflect.Int && f.Kind() <= reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case f.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case f.Kind() == reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		f.Set(reflect.ValueOf(list))
+	}
+	return nil
+}
+
+// format is the inverse of set.
+func (s setting) format() string {
+	f := s.field
+	switch {
+	case f.Type() == durationType:
+		return time.Duration(f.Int()).String()
+	case f.Kind() == reflect.Slice:
+		return strings.Join(f.Interface().([]string), ",")
+	default:
+		return fmt.Sprint(f.Interface())
+	}
+}
+
+// redacted returns a copy of the configuration struct v with the secret
+// settings that are set replaced by a placeholder.
+func redacted(v reflect.Value, settings []setting) interface{} {
+	out := reflect.New(v.Type()).Elem()
+	out.Set(v)
+	for _, s := range settings {
+		if s.secret && s.field.Kind() == reflect.String && s.field.String() != "" {
+			out.Field(s.index).SetString("REDACTED")
+		}
+	}
+	return out.Interface()
+}
+
+// rawValue is a flag.Value that keeps the command-line value as a string
+// until it is applied on top of the other sources.
+type rawValue struct {
+	s      string
+	isBool bool
+}
+
+func (r *rawValue) String() string {
+	if r == nil {
+		return ""
+	}
+	return r.s
+}
+
+func (r *rawValue) Set(s string) error {
+	r.s = s
+	return nil
+}
+
+func (r *rawValue) IsBoolFlag() bool { return r.isBool }
+
+// validatePort checks that port, named by name in error messages, is a valid
+// TCP port number.
+func validatePort(name, port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%s must be a port number between 1 and 65535, got %q", name, port)
+	}
+	return nil
+}
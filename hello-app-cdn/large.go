@@ -0,0 +1,135 @@
+/**
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxLargeObjectSize bounds the size of the objects served by largeObject.
+const maxLargeObjectSize = 10 << 30
+
+// sizeUnits are the suffixes accepted by parseSize.
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"B", 1},
+}
+
+// largeObject serves a generated binary object of the size given by the last
+// element of the path, such as /large/100MiB, to test the delivery of large
+// objects and byte-range requests. The content is the same on every request,
+// so the object can be cached and fetched in parts.
+func largeObject(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Serving request: %s", r.URL.Path)
+	size, err := parseSize(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if size > maxLargeObjectSize {
+		http.Error(w, fmt.Sprintf("size must be at most %d bytes", int64(maxLargeObjectSize)), http.StatusBadRequest)
+		return
+	}
+	tags := cache.tags(r.URL.Path)
+	setCacheTags(w.Header(), tags)
+	version, modified := versions.current(tags)
+	w.Header().Set("Cache-Control", cache.header(r.URL.Path))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	// the content only depends on the size
+	if etag := contentETag([]byte(strconv.FormatInt(size, 10)), version, cfg.ETag); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	serveContent(w, r, modified, &patternReader{size: size})
+}
+
+// parseSize parses a size in bytes, such as 512, 64KiB or 10MB.
+func parseSize(s string) (int64, error) {
+	digits, factor := s, int64(1)
+	for _, u := range sizeUnits {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			digits, factor = n, u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/factor {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * factor, nil
+}
+
+// patternReader generates size bytes of a repeating pattern, without holding
+// them in memory.
+type patternReader struct {
+	size, off int64
+}
+
+// pattern is 251 bytes long, a prime, so that parts of the object at
+// different offsets rarely look the same.
+var pattern = func() []byte {
+	p := make([]byte, 251)
+	for i := range p {
+		p[i] = byte(i)
+	}
+	return p
+}()
+
+func (r *patternReader) Read(b []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if remaining := r.size - r.off; int64(len(b)) > remaining {
+		b = b[:remaining]
+	}
+	for i := range b {
+		b[i] = pattern[(r.off+int64(i))%int64(len(pattern))]
+	}
+	r.off += int64(len(b))
+	return len(b), nil
+}
+
+func (r *patternReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.off = offset
+	return offset, nil
+}
@@ -17,36 +17,127 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
 )
 
+// config holds the settings of the server. They are loaded from a YAML file,
+// environment variables and flags by loadConfig.
+type config struct {
+	Port           string   `yaml:"port" env:"PORT" usage:"port to serve HTTP on"`
+	CacheControl   string   `yaml:"cacheControl" env:"CACHE_CONTROL" usage:"Cache-Control header sent with responses no cache rule applies to"`
+	CacheRules     string   `yaml:"cacheRules" env:"CACHE_RULES" usage:"path of a YAML file of per-path cache rules"`
+	ETag           string   `yaml:"etag" env:"ETAG" usage:"ETag validator sent with responses: strong, weak or none"`
+	CacheTags      []string `yaml:"cacheTags" env:"CACHE_TAGS" usage:"comma-separated cache tags of every response"`
+	CacheTagHeader string   `yaml:"cacheTagHeader" env:"CACHE_TAG_HEADER" usage:"response header listing the cache tags, e.g. Cache-Tag or Surrogate-Key"`
+	Compression    bool     `yaml:"compression" env:"COMPRESSION" usage:"compress responses with gzip or brotli when the client accepts it"`
+	StaticDir      string   `yaml:"staticDir" env:"STATIC_DIR" usage:"directory of static assets to serve (default the embedded sample assets)"`
+	StaticPath     string   `yaml:"staticPath" env:"STATIC_PATH" usage:"URL path to serve the static assets under"`
+	SignedPaths    []string `yaml:"signedPaths" env:"SIGNED_PATHS" usage:"comma-separated path prefixes that require a Cloud CDN signed URL or cookie"`
+	SignedKeys     string   `yaml:"signedKeys" env:"SIGNED_KEYS" usage:"path of a YAML file mapping signed URL key names to base64url encoded keys"`
+	MetricsPort    string   `yaml:"metricsPort" env:"METRICS_PORT" usage:"port to serve Prometheus metrics on"`
+}
+
+func (c *config) validate() error {
+	if err := validatePort("port", c.Port); err != nil {
+		return err
+	}
+	if err := validateTags(c.CacheTags); err != nil {
+		return fmt.Errorf("cacheTags: %v", err)
+	}
+	if c.CacheTagHeader == "" {
+		return errors.New("cacheTagHeader must be set")
+	}
+	if !slices.Contains(etagModes, c.ETag) {
+		return fmt.Errorf("etag must be strong, weak or none, got %q", c.ETag)
+	}
+	if !strings.HasPrefix(c.StaticPath, "/") || !strings.HasSuffix(c.StaticPath, "/") {
+		return fmt.Errorf("staticPath must start and end with /, got %q", c.StaticPath)
+	}
+	if len(c.SignedPaths) > 0 && c.SignedKeys == "" {
+		return errors.New("signedKeys must be set with signedPaths")
+	}
+	if err := validatePort("metricsPort", c.MetricsPort); err != nil {
+		return err
+	}
+	if c.Port == c.MetricsPort {
+		return errors.New("port and metricsPort must be different")
+	}
+	return nil
+}
+
+var cfg = config{
+	Port:           "8080",
+	CacheControl:   "max-age=86400,public",
+	CacheTagHeader: "Cache-Tag",
+	ETag:           "strong",
+	Compression:    true,
+	StaticPath:     "/static/",
+	MetricsPort:    "9090",
+}
+
+// cache selects the Cache-Control header of each response.
+var cache *cachePolicy
+
 func main() {
-	// use PORT environment variable, or default to 8080
-	port := "8080"
-	if fromEnv := os.Getenv("PORT"); fromEnv != "" {
-		port = fromEnv
+	if err := loadConfig(&cfg); err != nil {
+		log.Fatal(err)
+	}
+	var err error
+	if cache, err = loadCachePolicy(cfg.CacheRules, cfg.CacheControl, cfg.CacheTags); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.CacheRules != "" {
+		log.Printf("Loaded %d cache rules from %s", len(cache.rules), cfg.CacheRules)
 	}
 
-	// register hello function to handle all requests
+	assets, err := loadAssets(assetFS(cfg.StaticDir), cfg.StaticPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Serving %d static assets under %s", len(assets.byName), cfg.StaticPath)
+	signed := &signedContent{paths: cfg.SignedPaths}
+	if len(cfg.SignedPaths) > 0 {
+		if signed.keys, err = loadSignedKeys(cfg.SignedKeys); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Requiring signed URLs or cookies for %s", strings.Join(cfg.SignedPaths, ", "))
+	}
+	go serveMetrics(cfg.MetricsPort)
+
+	// register hello function to handle all requests, the static assets and
+	// the generated large objects
 	server := http.NewServeMux()
 	server.HandleFunc("/", hello)
+	server.Handle(cfg.StaticPath, assets)
+	server.HandleFunc("/large/", largeObject)
 
 	// start the web server on port and accept requests
-	log.Printf("Server listening on port %s", port)
-	err := http.ListenAndServe(":"+port, server)
+	log.Printf("Server listening on port %s", cfg.Port)
+	err = http.ListenAndServe(":"+cfg.Port, instrumentOrigin(server, signed.middleware(server)))
 	log.Fatal(err)
 }
 
 // hello responds to the request with a plain-text "Hello, world" message.
-// It also returns the appropriate headers to enable caching w/ the GCP CDN feature.
+// It also returns the appropriate headers to enable caching w/ the GCP CDN
+// feature, and validators for the CDN to revalidate cached responses with.
 func hello(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Serving request: %s", r.URL.Path)
 	host, _ := os.Hostname()
-	w.Header().Set("Cache-Control", "max-age=86400,public")
-	fmt.Fprintf(w, "Hello, world!\n")
-	fmt.Fprintf(w, "Version: 1.0.0\n")
-	fmt.Fprintf(w, "Hostname: %s\n", host)	
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "Hello, world!\n")
+	fmt.Fprintf(&body, "Version: 1.0.0\n")
+	fmt.Fprintf(&body, "Hostname: %s\n", host)
+	version, _ := versions.current(cache.tags(r.URL.Path))
+	fmt.Fprintf(&body, "Content version: %s\n", version)
+
+	w.Header().Set("Cache-Control", cache.header(r.URL.Path))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	serveConditional(w, r, body.Bytes(), nil)
 }
@@ -0,0 +1,68 @@
+/**
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	reg           = prometheus.NewRegistry()
+	responseCount = promauto.With(reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hello_app_cdn_responses_total",
+			Help: "Number of responses by type: full for unconditional requests, revalidated for conditional requests answered with 304 Not Modified, and modified for conditional requests answered in full.",
+		},
+		[]string{"type"},
+	)
+	originRequests = promauto.With(reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hello_app_cdn_origin_requests_total",
+			Help: "Number of requests that reached the origin, by route, status code and whether they went through a proxy that sets Via.",
+		},
+		[]string{"route", "code", "proxied"},
+	)
+	originBytes = promauto.With(reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hello_app_cdn_origin_response_bytes_total",
+			Help: "Number of response body bytes served by the origin, by route.",
+		},
+		[]string{"route"},
+	)
+	signedRequests = promauto.With(reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hello_app_cdn_signed_requests_total",
+			Help: "Number of requests to signed paths by result: valid, missing or invalid signature.",
+		},
+		[]string{"result"},
+	)
+)
+
+// serveMetrics exposes the metrics and the admin endpoints on their own port
+// so that they are not reachable through the load balancer and the CDN.
+func serveMetrics(port string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/admin/content-version", versions.handle)
+	log.Printf("Metrics and admin endpoints listening on port %s", port)
+	log.Fatal(http.ListenAndServe(":"+port, mux))
+}
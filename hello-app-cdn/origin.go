@@ -0,0 +1,97 @@
+/**
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// originLogHeaders are the request headers logged with each origin response.
+// Via is added by Google Cloud load balancers and other proxies; X-Cache and
+// similar headers are set by some CDNs and caches in front of the origin.
+var originLogHeaders = []string{"Via", "X-Cache", "X-Forwarded-For", "User-Agent"}
+
+// instrumentOrigin records every request that reaches the origin, that is,
+// every request the CDN did not serve from its cache: the number of requests
+// and bytes served by route on the metrics endpoint, and a structured log line
+// with the proxy headers of the request.
+//
+// The route of a request is the path of the cache rule that applies to it, or
+// else the pattern mux routes it with, which keeps the label cardinality
+// bounded.
+func instrumentOrigin(mux *http.ServeMux, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		cw := &countingWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(cw, r)
+
+		route := originRoute(mux, r)
+		code := strconv.Itoa(cw.status)
+		proxied := strconv.FormatBool(r.Header.Get("Via") != "")
+		originRequests.WithLabelValues(route, code, proxied).Inc()
+		originBytes.WithLabelValues(route).Add(float64(cw.bytes))
+
+		fields := []string{
+			"method=" + r.Method,
+			"path=" + strconv.Quote(r.URL.Path),
+			"route=" + route,
+			"status=" + code,
+			"bytes=" + strconv.FormatInt(cw.bytes, 10),
+			"duration=" + time.Since(start).String(),
+		}
+		for _, name := range originLogHeaders {
+			if v := r.Header.Get(name); v != "" {
+				fields = append(fields, strings.ToLower(strings.ReplaceAll(name, "-", "_"))+"="+strconv.Quote(v))
+			}
+		}
+		log.Printf("Origin response: %s", strings.Join(fields, " "))
+	})
+}
+
+// originRoute returns the route of a request.
+func originRoute(mux *http.ServeMux, r *http.Request) string {
+	if rule := cache.rule(r.URL.Path); rule != nil {
+		return rule.Path
+	}
+	_, pattern := mux.Handler(r)
+	if pattern == "" {
+		return "other"
+	}
+	return pattern
+}
+
+// countingWriter records the status code and body size of a response.
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *countingWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
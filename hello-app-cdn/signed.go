@@ -0,0 +1,232 @@
+/**
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// signedCookieName is the cookie Cloud CDN reads signed cookies from.
+const signedCookieName = "Cloud-CDN-Cookie"
+
+// errSignatureMissing is returned for requests without a signed URL or
+// cookie.
+var errSignatureMissing = errors.New("missing signature")
+
+// signedContent restricts paths to requests with a valid Cloud CDN signed URL
+// or signed cookie, as the CDN does before forwarding them to the origin:
+//
+//	URL?Expires=EXPIRES&KeyName=KEY&Signature=SIGNATURE
+//	URL?URLPrefix=PREFIX&Expires=EXPIRES&KeyName=KEY&Signature=SIGNATURE
+//	Cloud-CDN-Cookie=URLPrefix=PREFIX:Expires=EXPIRES:KeyName=KEY:Signature=SIGNATURE
+//
+// EXPIRES is a Unix timestamp, PREFIX is the base64url encoded URL prefix the
+// signature is valid for, and SIGNATURE is the base64url encoded HMAC-SHA1,
+// with the key named KEY, of everything that precedes the signature, without
+// the separator.
+type signedContent struct {
+	keys  map[string][]byte
+	paths []string
+}
+
+// loadSignedKeys reads a YAML file mapping key names to base64url encoded
+// keys, as created for Cloud CDN with:
+//
+//	head -c 16 /dev/urandom | base64 | tr +/ -_
+func loadSignedKeys(path string) (map[string][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading signed URL keys: %v", err)
+	}
+	var encoded map[string]string
+	if err := yaml.Unmarshal(data, &encoded); err != nil {
+		return nil, fmt.Errorf("parsing signed URL keys %s: %v", path, err)
+	}
+	keys := make(map[string][]byte)
+	for name, value := range encoded {
+		key, err := decodeBase64URL(value)
+		if err != nil {
+			return nil, fmt.Errorf("signed URL key %s: %v", name, err)
+		}
+		keys[name] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", path)
+	}
+	return keys, nil
+}
+
+// decodeBase64URL decodes base64url with or without padding.
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// middleware rejects the requests to the signed paths that do not carry a
+// valid signature with 403 Forbidden.
+func (s *signedContent) middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.requiresSignature(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if err := s.verify(r, time.Now()); err != nil {
+			log.Printf("Rejecting request: %s: %v", r.URL.Path, err)
+			result := "invalid"
+			if errors.Is(err, errSignatureMissing) {
+				result = "missing"
+			}
+			signedRequests.WithLabelValues(result).Inc()
+			http.Error(w, "403 Forbidden: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		signedRequests.WithLabelValues("valid").Inc()
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *signedContent) requiresSignature(path string) bool {
+	for _, p := range s.paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks the signed URL of r or, if it has none, its signed cookie.
+func (s *signedContent) verify(r *http.Request, now time.Time) error {
+	reqURL := requestURL(r)
+	if i := strings.Index(reqURL, "&Signature="); i >= 0 {
+		return s.verifyURL(reqURL, i, now)
+	}
+	if c, err := r.Cookie(signedCookieName); err == nil {
+		return s.verifyCookie(c.Value, reqURL, now)
+	}
+	return errSignatureMissing
+}
+
+// verifyURL checks a signed URL, whose Signature parameter starts at i. The
+// parameters are read from the signed part of the URL only, and must appear
+// once, so that unsigned parameters cannot override them.
+func (s *signedContent) verifyURL(reqURL string, i int, now time.Time) error {
+	signed, signature := reqURL[:i], reqURL[i+len("&Signature="):]
+	if strings.Contains(signature, "&") {
+		return errors.New("the Signature parameter must be last")
+	}
+	_, query, _ := strings.Cut(signed, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return fmt.Errorf("parsing query: %v", err)
+	}
+	for _, name := range []string{"URLPrefix", "Expires", "KeyName", "Signature"} {
+		if n := len(params[name]); n > 1 || name == "Signature" && n > 0 {
+			return fmt.Errorf("duplicate %s parameter", name)
+		}
+	}
+	if params.Has("URLPrefix") {
+		// only the parameters from URLPrefix on are signed
+		j := strings.Index(query, "URLPrefix=")
+		if j != 0 && query[j-1] != '&' {
+			return errors.New("malformed URLPrefix parameter")
+		}
+		signed = query[j:]
+		if params, err = url.ParseQuery(signed); err != nil {
+			return fmt.Errorf("parsing query: %v", err)
+		}
+	}
+	return s.check(signed, signature, params.Get("URLPrefix"), params.Get("KeyName"), params.Get("Expires"), reqURL, now)
+}
+
+// verifyCookie checks the value of a signed cookie.
+func (s *signedContent) verifyCookie(value, reqURL string, now time.Time) error {
+	signed, signature, ok := strings.Cut(value, ":Signature=")
+	if !ok {
+		return fmt.Errorf("%s cookie has no signature", signedCookieName)
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(signed, ":") {
+		k, v, _ := strings.Cut(field, "=")
+		fields[k] = v
+	}
+	if fields["URLPrefix"] == "" {
+		return fmt.Errorf("%s cookie has no URLPrefix", signedCookieName)
+	}
+	return s.check(signed, signature, fields["URLPrefix"], fields["KeyName"], fields["Expires"], reqURL, now)
+}
+
+// check verifies that signature is the signature of signed with the key named
+// keyName, that the signature has not expired and that reqURL starts with the
+// base64url encoded prefix, if any.
+func (s *signedContent) check(signed, signature, prefix, keyName, expires, reqURL string, now time.Time) error {
+	key, ok := s.keys[keyName]
+	if !ok {
+		return fmt.Errorf("unknown key %q", keyName)
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid Expires %q", expires)
+	}
+	sig, err := decodeBase64URL(signature)
+	if err != nil {
+		return fmt.Errorf("invalid Signature: %v", err)
+	}
+	mac := hmac.New(sha1.New, key)
+	mac.Write([]byte(signed))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("signature does not match")
+	}
+	if now.Unix() > exp {
+		return fmt.Errorf("signature expired at %s", time.Unix(exp, 0).UTC().Format(time.RFC3339))
+	}
+	if prefix != "" {
+		p, err := decodeBase64URL(prefix)
+		if err != nil {
+			return fmt.Errorf("invalid URLPrefix: %v", err)
+		}
+		if !strings.HasPrefix(reqURL, string(p)) {
+			return fmt.Errorf("URL does not start with signed prefix %s", p)
+		}
+	}
+	return nil
+}
+
+// requestURL returns the URL the client requested, as the CDN signs it. The
+// scheme is taken from X-Forwarded-Proto when the request went through a
+// proxy.
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
@@ -0,0 +1,87 @@
+/**
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSignedKey = []byte("0123456789abcdef")
+
+func sign(s string) string {
+	mac := hmac.New(sha1.New, testSignedKey)
+	mac.Write([]byte(s))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestSignedContentVerify(t *testing.T) {
+	s := &signedContent{keys: map[string][]byte{"key": testSignedKey}}
+	now := time.Unix(2000, 0)
+	prefix := base64.URLEncoding.EncodeToString([]byte("http://example.com/private/"))
+
+	signedURL := "http://example.com/private/a?Expires=3000&KeyName=key"
+	validURL := signedURL + "&Signature=" + sign(signedURL)
+	expiredURL := "http://example.com/private/a?Expires=1000&KeyName=key"
+	expiredURL += "&Signature=" + sign(expiredURL)
+
+	prefixParams := "URLPrefix=" + prefix + "&Expires=3000&KeyName=key"
+	validPrefix := "?" + prefixParams + "&Signature=" + sign(prefixParams)
+	expiredParams := "URLPrefix=" + prefix + "&Expires=1000&KeyName=key"
+	expiredPrefix := "?" + expiredParams + "&Signature=" + sign(expiredParams)
+
+	cookie := "URLPrefix=" + prefix + ":Expires=3000:KeyName=key"
+	cookie += ":Signature=" + sign(cookie)
+
+	for name, tc := range map[string]struct {
+		url, cookie string
+		want        string // empty if the request is valid
+	}{
+		"signed URL":            {url: validURL},
+		"expired signed URL":    {url: expiredURL, want: "expired"},
+		"tampered signed URL":   {url: strings.Replace(validURL, "3000", "4000", 1), want: "does not match"},
+		"URL prefix":            {url: "http://example.com/private/b" + validPrefix},
+		"outside URL prefix":    {url: "http://example.com/public/b" + validPrefix, want: "signed prefix"},
+		"expired URL prefix":    {url: "http://example.com/private/b" + expiredPrefix, want: "expired"},
+		"unsigned Expires":      {url: "http://example.com/private/b?Expires=9999999999&" + expiredPrefix[1:], want: "duplicate Expires"},
+		"unsigned KeyName":      {url: "http://example.com/private/b?KeyName=other&" + validPrefix[1:], want: "duplicate KeyName"},
+		"unsigned URLPrefix":    {url: "http://example.com/private/b?URLPrefix=" + prefix + "&" + validPrefix[1:], want: "duplicate URLPrefix"},
+		"duplicate Signature":   {url: "http://example.com/private/b?Signature=x&" + validPrefix[1:], want: "duplicate Signature"},
+		"unsigned other fields": {url: "http://example.com/private/b?x=1&" + validPrefix[1:]},
+		"cookie":                {url: "http://example.com/private/c", cookie: cookie},
+		"missing signature":     {url: "http://example.com/private/c", want: "missing"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.url, nil)
+			if tc.cookie != "" {
+				r.Header.Set("Cookie", signedCookieName+"="+tc.cookie)
+			}
+			err := s.verify(r, now)
+			switch {
+			case tc.want == "" && err != nil:
+				t.Errorf("verify(%s) = %v, want no error", tc.url, err)
+			case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+				t.Errorf("verify(%s) = %v, want an error containing %q", tc.url, err, tc.want)
+			}
+		})
+	}
+}
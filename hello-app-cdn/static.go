@@ -0,0 +1,215 @@
+/**
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// embeddedAssets are the sample assets served when staticDir is not set.
+//
+//go:embed static
+var embeddedAssets embed.FS
+
+// immutableCacheControl is sent with the content-hashed asset names, whose
+// content never changes.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// precompressed lists the content codings of the pre-compressed variants of
+// an asset, in order of preference, and the suffix of their file names.
+var precompressed = []struct {
+	coding, suffix string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+func init() {
+	// Types missing from the Go table, which distroless images do not extend
+	// with /etc/mime.types.
+	for ext, typ := range map[string]string{
+		".ico":         "image/x-icon",
+		".map":         "application/json",
+		".txt":         "text/plain; charset=utf-8",
+		".webmanifest": "application/manifest+json",
+		".woff":        "font/woff",
+		".woff2":       "font/woff2",
+	} {
+		mime.AddExtensionType(ext, typ)
+	}
+}
+
+// asset is a static file, held in memory.
+type asset struct {
+	name        string // path relative to the asset directory
+	hashedName  string // name with the content hash before the extension
+	contentType string
+	content     []byte
+	// variants holds the pre-compressed content by content coding.
+	variants map[string][]byte
+}
+
+// assetServer serves the files of a directory under prefix, both by their
+// name and by their content-hashed name, such as app.3f2a9c1e07.css. Since a
+// hashed name changes with the content, its responses are cached forever;
+// responses for the plain names follow the cache rules.
+//
+// A file named NAME.br or NAME.gz next to NAME is its pre-compressed variant,
+// served to clients that accept the encoding instead of compressing NAME.
+type assetServer struct {
+	prefix string
+	byName map[string]*asset
+	hashed map[string]*asset
+}
+
+// loadAssets reads the files of fsys into memory.
+func loadAssets(fsys fs.FS, prefix string) (*assetServer, error) {
+	s := &assetServer{
+		prefix: prefix,
+		byName: make(map[string]*asset),
+		hashed: make(map[string]*asset),
+	}
+	files := make(map[string][]byte)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		content, err := fs.ReadFile(fsys, name)
+		files[name] = content
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading static assets: %v", err)
+	}
+
+	for name, content := range files {
+		if isVariant(name, files) {
+			continue
+		}
+		sum := sha256.Sum256(content)
+		a := &asset{
+			name:        name,
+			hashedName:  hashedName(name, hex.EncodeToString(sum[:5])),
+			contentType: mime.TypeByExtension(path.Ext(name)),
+			content:     content,
+			variants:    make(map[string][]byte),
+		}
+		if a.contentType == "" {
+			a.contentType = http.DetectContentType(content)
+		}
+		for _, p := range precompressed {
+			if v, ok := files[name+p.suffix]; ok {
+				a.variants[p.coding] = v
+			}
+		}
+		s.byName[a.name] = a
+		s.hashed[a.hashedName] = a
+	}
+	return s, nil
+}
+
+// isVariant reports whether name is the pre-compressed variant of another of
+// the files.
+func isVariant(name string, files map[string][]byte) bool {
+	for _, p := range precompressed {
+		if base, ok := strings.CutSuffix(name, p.suffix); ok {
+			if _, ok := files[base]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hashedName inserts hash before the extension of name.
+func hashedName(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// assetFS returns the directory to serve the assets from: dir if it is set,
+// the embedded sample assets otherwise.
+func assetFS(dir string) fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	sub, err := fs.Sub(embeddedAssets, "static")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+func (s *assetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Serving request: %s", r.URL.Path)
+	name := strings.TrimPrefix(r.URL.Path, s.prefix)
+	if name == "manifest.json" {
+		s.serveManifest(w, r)
+		return
+	}
+
+	cacheControl := cache.header(r.URL.Path)
+	a, ok := s.hashed[name]
+	if ok {
+		cacheControl = immutableCacheControl
+	} else if a, ok = s.byName[name]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("Content-Type", a.contentType)
+	serveConditional(w, r, a.content, a.variants)
+}
+
+// serveManifest responds with the content-hashed name of every asset, by
+// name, for pages to reference the assets with.
+func (s *assetServer) serveManifest(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(s.byName))
+	for name := range s.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	manifest := make(map[string]string)
+	for _, name := range names {
+		manifest[name] = s.prefix + s.byName[name].hashedName
+	}
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+	w.Header().Set("Cache-Control", cache.header(r.URL.Path))
+	w.Header().Set("Content-Type", "application/json")
+	serveConditional(w, r, body, nil)
+}
@@ -0,0 +1,34 @@
+// Script of the hello-app-cdn sample frontend. It shows the response headers
+// that tell whether the page was served by Cloud CDN or by the backend.
+
+(function () {
+  'use strict';
+
+  var headers = ['Age', 'Cache-Control', 'ETag', 'Last-Modified', 'Via', 'X-Cache'];
+
+  function show(response) {
+    var table = document.getElementById('headers');
+    if (!table) {
+      return;
+    }
+    headers.forEach(function (name) {
+      var value = response.headers.get(name);
+      if (value === null) {
+        return;
+      }
+      var row = table.insertRow();
+      var th = document.createElement('th');
+      th.textContent = name;
+      row.appendChild(th);
+      row.insertCell().textContent = value;
+    });
+  }
+
+  document.addEventListener('DOMContentLoaded', function () {
+    fetch(window.location.href, {method: 'HEAD', cache: 'no-store'})
+      .then(show)
+      .catch(function (err) {
+        console.error('Failed to read response headers:', err);
+      });
+  });
+})();
@@ -0,0 +1,103 @@
+/**
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+)
+
+// etagModes lists the values of the etag setting.
+var etagModes = []string{"strong", "weak", "none"}
+
+// startTime is reported as the Last-Modified time of the responses until
+// their content version is bumped.
+var startTime = time.Now()
+
+// contentETag returns the ETag of body at the given content version for the
+// given etag setting, or "" if ETags are disabled. A weak ETag tells caches
+// that the response is only semantically equivalent to others with the same
+// ETag, for example when it is compressed differently.
+func contentETag(body []byte, version, mode string) string {
+	if mode == "none" {
+		return ""
+	}
+	h := sha256.New()
+	h.Write([]byte(version + "\n"))
+	h.Write(body)
+	sum := h.Sum(nil)
+	tag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	if mode == "weak" {
+		tag = "W/" + tag
+	}
+	return tag
+}
+
+// serveConditional writes body, compressed if the client accepts it, with
+// its cache tags and its ETag and Last-Modified validators, and answers If-None-Match and
+// If-Modified-Since requests with 304 Not Modified when they still match.
+// Range requests are answered with the requested parts of the encoded body.
+// variants holds pre-compressed versions of body, if any, by content coding.
+func serveConditional(w http.ResponseWriter, r *http.Request, body []byte, variants map[string][]byte) {
+	tags := cache.tags(r.URL.Path)
+	setCacheTags(w.Header(), tags)
+	version, modified := versions.current(tags)
+
+	encoded := encodeBody(w, r, body, variants)
+	// A strong ETag identifies the exact bytes, so each encoding gets its own,
+	// while a weak ETag is shared by the encodings of the same content.
+	etag := contentETag(encoded, version, cfg.ETag)
+	if cfg.ETag == "weak" {
+		etag = contentETag(body, version, cfg.ETag)
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	serveContent(w, r, modified, bytes.NewReader(encoded))
+}
+
+// serveContent writes content with the Last-Modified validator, answering
+// conditional and range requests, and counts the response.
+func serveContent(w http.ResponseWriter, r *http.Request, modified time.Time, content io.ReadSeeker) {
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	http.ServeContent(sw, r, "", modified, content)
+
+	conditional := r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+	switch {
+	case sw.status == http.StatusNotModified:
+		responseCount.WithLabelValues("revalidated").Inc()
+	case conditional:
+		responseCount.WithLabelValues("modified").Inc()
+	default:
+		responseCount.WithLabelValues("full").Inc()
+	}
+}
+
+// statusWriter records the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
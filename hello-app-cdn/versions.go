@@ -0,0 +1,152 @@
+/**
+ * Copyright 2019 Google Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contentVersions stands in for the content of the site changing: bumping
+// the version of every response, or of the responses with a given cache tag,
+// changes their ETag and Last-Modified validators, as a deployment would.
+// Together with the cache tags, it is used to rehearse invalidating the CDN
+// by tag and check that the changed responses are fetched again.
+type contentVersions struct {
+	mtx    sync.Mutex
+	global version
+	tags   map[string]version
+}
+
+// version is the version of some content and the time it was last bumped.
+type version struct {
+	Version  int       `json:"version"`
+	Modified time.Time `json:"modified"`
+}
+
+// versions holds the content versions of the server.
+var versions = &contentVersions{
+	global: version{Version: 1, Modified: startTime},
+	tags:   make(map[string]version),
+}
+
+// current returns the version of a response with the given cache tags, for
+// its ETag to be computed from, and the time the response last changed.
+func (v *contentVersions) current(tags []string) (string, time.Time) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	id := fmt.Sprint(v.global.Version)
+	modified := v.global.Modified
+	for _, tag := range tags {
+		if tv, ok := v.tags[tag]; ok {
+			id += fmt.Sprintf(" %s=%d", tag, tv.Version)
+			if tv.Modified.After(modified) {
+				modified = tv.Modified
+			}
+		}
+	}
+	return id, modified
+}
+
+// bump increments the version of the responses with tag, or of every response
+// if tag is empty.
+func (v *contentVersions) bump(tag string) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	// Last-Modified has a resolution of one second, so each bump must move it
+	// to a later second for If-Modified-Since to see the change.
+	now := time.Now().Truncate(time.Second)
+	latest := v.global.Modified
+	for _, tv := range v.tags {
+		if tv.Modified.After(latest) {
+			latest = tv.Modified
+		}
+	}
+	if !now.After(latest.Truncate(time.Second)) {
+		now = latest.Truncate(time.Second).Add(time.Second)
+	}
+	if tag == "" {
+		v.global = version{Version: v.global.Version + 1, Modified: now}
+		return
+	}
+	tv, ok := v.tags[tag]
+	if !ok {
+		tv.Version = 1
+	}
+	v.tags[tag] = version{Version: tv.Version + 1, Modified: now}
+}
+
+// versionsResponse is the body of the content version admin endpoint.
+type versionsResponse struct {
+	version
+	Tags map[string]version `json:"tags,omitempty"`
+}
+
+// handle serves the content version admin endpoint: GET reports the versions,
+// and POST bumps the version of the responses with the tag given by the tag
+// query parameter, or of every response if there is none.
+func (v *contentVersions) handle(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Serving request: %s", r.URL.Path)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		tag := r.URL.Query().Get("tag")
+		v.bump(tag)
+		if tag == "" {
+			tag = "all content"
+		}
+		log.Printf("Bumped content version of %s", tag)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	v.mtx.Lock()
+	resp := versionsResponse{version: v.global, Tags: make(map[string]version)}
+	for tag, tv := range v.tags {
+		resp.Tags[tag] = tv
+	}
+	v.mtx.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(resp); err != nil {
+		log.Printf("Failed to write content versions: %v", err)
+	}
+}
+
+// setCacheTags lists tags in the cacheTagHeader header of the response.
+// Surrogate-Key values are separated by spaces, others by commas.
+func setCacheTags(h http.Header, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	sep := ","
+	if strings.EqualFold(cfg.CacheTagHeader, "Surrogate-Key") {
+		sep = " "
+	}
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	h.Set(cfg.CacheTagHeader, strings.Join(sorted, sep))
+}
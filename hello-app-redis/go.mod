@@ -2,7 +2,10 @@ module hello-app
 
 go 1.21
 
-require github.com/redis/go-redis/v9 v9.2.1
+require (
+	github.com/redis/go-redis/v9 v9.2.1
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
@@ -0,0 +1,193 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// clusterSlots is the number of hash slots of a Redis cluster.
+const clusterSlots = 16384
+
+// redisStatus is the result of a readiness check of Redis.
+type redisStatus struct {
+	Mode      string         `json:"mode"`
+	OK        bool           `json:"ok"`
+	Error     string         `json:"error,omitempty"`
+	Latency   string         `json:"latency,omitempty"`
+	CheckedAt time.Time      `json:"checkedAt"`
+	Cluster   *clusterStatus `json:"cluster,omitempty"`
+}
+
+// clusterStatus describes a Redis cluster, as seen by the client.
+type clusterStatus struct {
+	State        string       `json:"state,omitempty"`
+	SlotsCovered int          `json:"slotsCovered"`
+	SlotsTotal   int          `json:"slotsTotal"`
+	Nodes        []nodeStatus `json:"nodes"`
+}
+
+// nodeStatus is the result of pinging a node of a Redis cluster.
+type nodeStatus struct {
+	Addr    string `json:"addr"`
+	Role    string `json:"role"`
+	OK      bool   `json:"ok"`
+	Latency string `json:"latency,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// redisChecker checks whether Redis can serve requests. Results are cached
+// for ttl, so that frequent health checks from several sources do not add
+// load on Redis, and concurrent callers share a single check.
+type redisChecker struct {
+	client  redis.UniversalClient
+	mode    string
+	timeout time.Duration
+	ttl     time.Duration
+
+	mtx  sync.Mutex
+	last *redisStatus
+}
+
+func newRedisChecker(client redis.UniversalClient, mode string, timeout, ttl time.Duration) *redisChecker {
+	return &redisChecker{client: client, mode: mode, timeout: timeout, ttl: ttl}
+}
+
+// status returns the cached result of the last check if it is recent enough,
+// or checks Redis again.
+func (c *redisChecker) status() *redisStatus {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.last != nil && time.Since(c.last.CheckedAt) < c.ttl {
+		return c.last
+	}
+
+	start := time.Now()
+	s := c.check()
+	s.Latency = latency(start)
+	s.CheckedAt = time.Now()
+	c.last = s
+	return s
+}
+
+// check checks Redis within the timeout. The client only applies its own read
+// and write timeouts to commands, so the check is abandoned, rather than
+// cancelled, when it takes too long.
+func (c *redisChecker) check() *redisStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	done := make(chan *redisStatus, 1)
+	go func() {
+		s := &redisStatus{Mode: c.mode}
+		var err error
+		if cluster, ok := c.client.(*redis.ClusterClient); ok {
+			s.Cluster, err = checkCluster(ctx, cluster)
+		} else {
+			err = c.client.Ping(ctx).Err()
+		}
+		if err != nil {
+			s.Error = err.Error()
+		} else {
+			s.OK = true
+		}
+		done <- s
+	}()
+
+	select {
+	case s := <-done:
+		return s
+	case <-ctx.Done():
+		return &redisStatus{Mode: c.mode, Error: fmt.Sprintf("no response within %v", c.timeout)}
+	}
+}
+
+// checkCluster pings every node of the cluster, and reports the cluster state
+// and how many slots are served. It fails if a primary cannot be reached or if
+// the cluster cannot serve every slot; unreachable replicas are only reported.
+func checkCluster(ctx context.Context, client *redis.ClusterClient) (*clusterStatus, error) {
+	s := &clusterStatus{SlotsTotal: clusterSlots, Nodes: []nodeStatus{}}
+
+	var mtx sync.Mutex
+	ping := func(role string) func(context.Context, *redis.Client) error {
+		return func(ctx context.Context, node *redis.Client) error {
+			start := time.Now()
+			err := node.Ping(ctx).Err()
+			n := nodeStatus{Addr: node.Options().Addr, Role: role, OK: err == nil, Latency: latency(start)}
+			if err != nil {
+				n.Error = err.Error()
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			s.Nodes = append(s.Nodes, n)
+			return nil
+		}
+	}
+	if err := client.ForEachMaster(ctx, ping("primary")); err != nil {
+		return s, err
+	}
+	if err := client.ForEachSlave(ctx, ping("replica")); err != nil {
+		return s, err
+	}
+	sort.Slice(s.Nodes, func(i, j int) bool {
+		if s.Nodes[i].Role != s.Nodes[j].Role {
+			return s.Nodes[i].Role == "primary"
+		}
+		return s.Nodes[i].Addr < s.Nodes[j].Addr
+	})
+	for _, n := range s.Nodes {
+		if n.Role == "primary" && !n.OK {
+			return s, fmt.Errorf("primary %s is unreachable: %s", n.Addr, n.Error)
+		}
+	}
+
+	info, err := client.ClusterInfo(ctx).Result()
+	if err != nil {
+		return s, fmt.Errorf("failed to get cluster info: %w", err)
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if state, ok := strings.CutPrefix(strings.TrimSpace(line), "cluster_state:"); ok {
+			s.State = state
+		}
+	}
+	slots, err := client.ClusterSlots(ctx).Result()
+	if err != nil {
+		return s, fmt.Errorf("failed to get cluster slots: %w", err)
+	}
+	for _, slot := range slots {
+		s.SlotsCovered += slot.End - slot.Start + 1
+	}
+
+	if s.State != "ok" {
+		return s, fmt.Errorf("cluster state is %q", s.State)
+	}
+	if s.SlotsCovered < s.SlotsTotal {
+		return s, fmt.Errorf("only %d of %d slots are covered", s.SlotsCovered, s.SlotsTotal)
+	}
+	return s, nil
+}
+
+func latency(start time.Time) string {
+	return time.Since(start).Round(time.Microsecond).String()
+}
@@ -18,11 +18,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -34,16 +39,22 @@ const resourcePoolSize = 50
 type resourcePool struct {
 	mtx       sync.Mutex
 	allocated int
+	// idle is closed when the last allocation is released, while some are.
+	idle chan struct{}
 }
 
 var pool resourcePool
-var redisClusterClient *redis.ClusterClient
+var redisClient redis.UniversalClient
+var redisHealth *redisChecker
 var ctx = context.Background()
 
 func (p *resourcePool) alloc() bool {
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
 	if p.allocated < resourcePoolSize*0.9 {
+		if p.allocated == 0 {
+			p.idle = make(chan struct{})
+		}
 		p.allocated++
 		return true
 	}
@@ -54,6 +65,9 @@ func (p *resourcePool) release() {
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
 	p.allocated--
+	if p.allocated == 0 {
+		close(p.idle)
+	}
 }
 
 func (p *resourcePool) hasResources() bool {
@@ -62,44 +76,173 @@ func (p *resourcePool) hasResources() bool {
 	return p.allocated < resourcePoolSize
 }
 
+// wait blocks until all allocations are released, or ctx is done.
+func (p *resourcePool) wait(ctx context.Context) error {
+	p.mtx.Lock()
+	if p.allocated == 0 {
+		p.mtx.Unlock()
+		return nil
+	}
+	idle := p.idle
+	p.mtx.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		p.mtx.Lock()
+		defer p.mtx.Unlock()
+		return fmt.Errorf("%d resources still allocated: %w", p.allocated, ctx.Err())
+	}
+}
+
 // End of resource pool code.
 
+// config holds the settings of the server. They are loaded from a YAML file,
+// environment variables and flags by loadConfig.
+type config struct {
+	Port string `yaml:"port" env:"PORT" usage:"port to serve HTTP on"`
+
+	RedisMode             string   `yaml:"redisMode" env:"REDIS_MODE" usage:"how to connect to Redis: cluster, standalone or sentinel"`
+	RedisAddrs            []string `yaml:"redisAddrs" env:"REDIS_ADDRS" usage:"comma-separated host:port addresses of the cluster seed nodes, the server, or the Sentinels"`
+	RedisUsername         string   `yaml:"redisUsername" env:"REDIS_USERNAME" usage:"ACL username"`
+	RedisPassword         string   `yaml:"redisPassword" env:"REDIS_PASSWORD" secret:"true" usage:"password, or AUTH string"`
+	RedisDB               int      `yaml:"redisDB" env:"REDIS_DB" usage:"database number, in standalone and sentinel modes"`
+	RedisSentinelMaster   string   `yaml:"redisSentinelMaster" env:"REDIS_SENTINEL_MASTER" usage:"name of the primary monitored by the Sentinels"`
+	RedisSentinelUsername string   `yaml:"redisSentinelUsername" env:"REDIS_SENTINEL_USERNAME" usage:"ACL username of the Sentinels"`
+	RedisSentinelPassword string   `yaml:"redisSentinelPassword" env:"REDIS_SENTINEL_PASSWORD" secret:"true" usage:"password of the Sentinels"`
+
+	RedisTLS           bool   `yaml:"redisTLS" env:"REDIS_TLS" usage:"connect to Redis over TLS"`
+	RedisTLSCAFile     string `yaml:"redisTLSCAFile" flag:"redis-tls-ca-file" env:"REDIS_TLS_CA_FILE" usage:"path of a PEM bundle of the CAs that sign the Redis server certificates (implies redisTLS)"`
+	RedisTLSServerName string `yaml:"redisTLSServerName" env:"REDIS_TLS_SERVER_NAME" usage:"name to verify the Redis server certificates against (default the host of each address)"`
+
+	RedisDialTimeout    time.Duration `yaml:"redisDialTimeout" env:"REDIS_DIAL_TIMEOUT" usage:"timeout for connecting to Redis"`
+	RedisReadTimeout    time.Duration `yaml:"redisReadTimeout" env:"REDIS_READ_TIMEOUT" usage:"timeout for reading a reply from Redis"`
+	RedisWriteTimeout   time.Duration `yaml:"redisWriteTimeout" env:"REDIS_WRITE_TIMEOUT" usage:"timeout for sending a command to Redis"`
+	RedisPoolSize       int           `yaml:"redisPoolSize" env:"REDIS_POOL_SIZE" usage:"maximum number of connections per Redis node (default 10 per CPU, 5 per CPU in cluster mode)"`
+	RedisRouteByLatency bool          `yaml:"redisRouteByLatency" env:"REDIS_ROUTE_BY_LATENCY" usage:"send read-only commands to the closest node, in cluster mode"`
+
+	ShutdownDelay   time.Duration `yaml:"shutdownDelay" env:"SHUTDOWN_DELAY" usage:"time to keep serving, while failing health checks, after SIGTERM"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT" usage:"time to wait for in-flight requests to complete when shutting down"`
+
+	ReadinessTimeout  time.Duration `yaml:"readinessTimeout" env:"READINESS_TIMEOUT" usage:"timeout for checking Redis in readiness checks"`
+	ReadinessCacheTTL time.Duration `yaml:"readinessCacheTTL" env:"READINESS_CACHE_TTL" usage:"time to reuse the result of a Redis readiness check for"`
+}
+
+func (c *config) validate() error {
+	if err := validatePort("port", c.Port); err != nil {
+		return err
+	}
+	if c.ShutdownDelay < 0 || c.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdownDelay and shutdownTimeout cannot be negative")
+	}
+	if c.ReadinessTimeout <= 0 {
+		return fmt.Errorf("readinessTimeout must be positive, got %v", c.ReadinessTimeout)
+	}
+	if c.ReadinessCacheTTL < 0 {
+		return fmt.Errorf("readinessCacheTTL cannot be negative, got %v", c.ReadinessCacheTTL)
+	}
+	return c.validateRedis()
+}
+
 func main() {
-	// use PORT environment variable, or default to 8080
-	port := "8080"
-	if fromEnv := os.Getenv("PORT"); fromEnv != "" {
-		port = fromEnv
+	cfg := config{
+		Port:                "8080",
+		RedisMode:           "cluster",
+		RedisAddrs:          []string{"redis-cluster:6379"},
+		RedisDialTimeout:    5 * time.Second,
+		RedisReadTimeout:    3 * time.Second,
+		RedisWriteTimeout:   3 * time.Second,
+		RedisRouteByLatency: true,
+		ShutdownDelay:       5 * time.Second,
+		ShutdownTimeout:     20 * time.Second,
+		ReadinessTimeout:    500 * time.Millisecond,
+		ReadinessCacheTTL:   2 * time.Second,
+	}
+	if err := loadConfig(&cfg); err != nil {
+		log.Fatal(err)
 	}
 
 	// register hello function to handle all requests
 	server := http.NewServeMux()
+	server.HandleFunc("/livez", livez)
+	server.HandleFunc("/readyz", healthz)
 	server.HandleFunc("/healthz", healthz)
 	server.HandleFunc("/", hello)
 
-	// connect to redis cluster
-	redisClusterClient = redis.NewClusterClient(&redis.ClusterOptions{
-		Addrs:          []string{"redis-cluster:6379"},
-		RouteByLatency: true})
-	defer redisClusterClient.Close()
+	// connect to redis
+	var err error
+	redisClient, err = newRedisClient(&cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	redisHealth = newRedisChecker(redisClient, cfg.RedisMode, cfg.ReadinessTimeout, cfg.ReadinessCacheTTL)
+	log.Printf("Connecting to redis in %s mode at %s", cfg.RedisMode, strings.Join(cfg.RedisAddrs, ", "))
 
 	// start the web server on port and accept requests
-	log.Printf("Server listening on port %s", port)
-	err := http.ListenAndServe(":"+port, server)
-	log.Fatal(err)
+	httpServer := &http.Server{Addr: ":" + cfg.Port, Handler: server}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Server listening on port %s", cfg.Port)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	// stop gracefully on SIGTERM, as sent by Kubernetes, or on interrupt
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-serveErr:
+		redisClient.Close()
+		log.Fatal(err)
+	case sig := <-signals:
+		signal.Stop(signals)
+		log.Printf("Received %v, shutting down", sig)
+	}
+	shutdown(httpServer, cfg.ShutdownDelay, cfg.ShutdownTimeout)
 }
 
 // Start of healthz code.
 
+// readiness is the response of the readiness check.
+type readiness struct {
+	Ready  bool         `json:"ready"`
+	Reason string       `json:"reason,omitempty"`
+	Redis  *redisStatus `json:"redis,omitempty"`
+}
+
+// livez reports that the server is alive. It does not depend on Redis, so
+// that the container is not restarted while Redis is unavailable.
+func livez(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "Ok\n")
+}
+
+// healthz reports whether the server is ready to serve requests: it is not
+// shutting down, has resources left in the pool, and can use Redis.
 func healthz(w http.ResponseWriter, r *http.Request) {
 	// Log to make it simple to validate if healt checks are happening.
 	log.Printf("Serving healthcheck: %s", r.URL.Path)
-	if pool.hasResources() {
-		fmt.Fprintf(w, "Ok\n")
-		return
+	var status readiness
+	switch {
+	case shuttingDown.Load():
+		status.Reason = "shutting down"
+	case !pool.hasResources():
+		status.Reason = "tight resource constraints in the pool"
+	default:
+		status.Redis = redisHealth.status()
+		if status.Redis.OK {
+			status.Ready = true
+		} else {
+			status.Reason = "redis is unavailable: " + status.Redis.Error
+		}
 	}
 
-	w.WriteHeader(http.StatusServiceUnavailable)
-	w.Write([]byte("503 - Error due to tight resource constraints in the pool!"))
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(status)
 }
 
 // End of healthz code.
@@ -117,7 +260,7 @@ func hello(w http.ResponseWriter, r *http.Request) {
 		defer pool.release()
 	}
 
-	count, err := redisClusterClient.Incr(ctx, "hits").Result()
+	count, err := redisClient.Incr(ctx, "hits").Result()
 	if err != nil {
 		w.Write([]byte("500 - Error due to redis cluster broken!\n" + fmt.Sprintf("%v", err)))
 		return
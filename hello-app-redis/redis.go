@@ -0,0 +1,147 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisModes lists the values of the redisMode setting:
+//
+//	cluster     a Redis Cluster, such as the redis-cluster StatefulSet or
+//	            Memorystore for Redis Cluster, discovered from redisAddrs
+//	standalone  a single Redis server or primary, such as a Memorystore for
+//	            Redis instance, at the only address of redisAddrs
+//	sentinel    the primary of the group named redisSentinelMaster, found
+//	            through the Sentinels at redisAddrs
+var redisModes = []string{"cluster", "standalone", "sentinel"}
+
+// validateRedis checks the settings of the Redis client.
+func (c *config) validateRedis() error {
+	if len(c.RedisAddrs) == 0 {
+		return errors.New("redisAddrs must be set")
+	}
+	switch c.RedisMode {
+	case "cluster":
+		if c.RedisDB != 0 {
+			return errors.New("redisDB cannot be set in cluster mode, which only has database 0")
+		}
+	case "standalone":
+		if len(c.RedisAddrs) != 1 {
+			return fmt.Errorf("redisAddrs must have a single address in standalone mode, got %d", len(c.RedisAddrs))
+		}
+	case "sentinel":
+		if c.RedisSentinelMaster == "" {
+			return errors.New("redisSentinelMaster must be set in sentinel mode")
+		}
+	default:
+		return fmt.Errorf("redisMode must be %s, got %q", strings.Join(redisModes, ", "), c.RedisMode)
+	}
+	for name, d := range map[string]int64{
+		"redisDialTimeout":  int64(c.RedisDialTimeout),
+		"redisReadTimeout":  int64(c.RedisReadTimeout),
+		"redisWriteTimeout": int64(c.RedisWriteTimeout),
+	} {
+		if d < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	if c.RedisPoolSize < 0 {
+		return errors.New("redisPoolSize must not be negative")
+	}
+	return nil
+}
+
+// redisTLSConfig returns the TLS configuration of the connections to Redis,
+// or nil if TLS is disabled. TLS is enabled by redisTLS or by setting a CA
+// bundle.
+func (c *config) redisTLSConfig() (*tls.Config, error) {
+	if !c.RedisTLS && c.RedisTLSCAFile == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: c.RedisTLSServerName,
+	}
+	if c.RedisTLSCAFile != "" {
+		pem, err := os.ReadFile(c.RedisTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading Redis CA bundle: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in Redis CA bundle %s", c.RedisTLSCAFile)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// newRedisClient connects to Redis in the configured mode.
+func newRedisClient(c *config) (redis.UniversalClient, error) {
+	tlsConfig, err := c.redisTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	switch c.RedisMode {
+	case "standalone":
+		return redis.NewClient(&redis.Options{
+			Addr:         c.RedisAddrs[0],
+			Username:     c.RedisUsername,
+			Password:     c.RedisPassword,
+			DB:           c.RedisDB,
+			TLSConfig:    tlsConfig,
+			DialTimeout:  c.RedisDialTimeout,
+			ReadTimeout:  c.RedisReadTimeout,
+			WriteTimeout: c.RedisWriteTimeout,
+			PoolSize:     c.RedisPoolSize,
+		}), nil
+	case "sentinel":
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       c.RedisSentinelMaster,
+			SentinelAddrs:    c.RedisAddrs,
+			SentinelUsername: c.RedisSentinelUsername,
+			SentinelPassword: c.RedisSentinelPassword,
+			Username:         c.RedisUsername,
+			Password:         c.RedisPassword,
+			DB:               c.RedisDB,
+			TLSConfig:        tlsConfig,
+			DialTimeout:      c.RedisDialTimeout,
+			ReadTimeout:      c.RedisReadTimeout,
+			WriteTimeout:     c.RedisWriteTimeout,
+			PoolSize:         c.RedisPoolSize,
+		}), nil
+	default:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:          c.RedisAddrs,
+			Username:       c.RedisUsername,
+			Password:       c.RedisPassword,
+			TLSConfig:      tlsConfig,
+			DialTimeout:    c.RedisDialTimeout,
+			ReadTimeout:    c.RedisReadTimeout,
+			WriteTimeout:   c.RedisWriteTimeout,
+			PoolSize:       c.RedisPoolSize,
+			RouteByLatency: c.RedisRouteByLatency,
+		}), nil
+	}
+}
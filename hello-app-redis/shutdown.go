@@ -0,0 +1,55 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// shuttingDown is set once the server starts shutting down, to fail health
+// checks so that the Pod is removed from the Service endpoints.
+var shuttingDown atomic.Bool
+
+// shutdown stops the server without dropping requests. It first keeps serving
+// for delay while failing health checks, as load balancers and kube-proxy take
+// a few seconds to stop sending new requests to a terminating Pod. It then
+// stops accepting connections, waits up to timeout for in-flight requests and
+// resource pool allocations to complete, and closes the Redis client.
+func shutdown(server *http.Server, delay, timeout time.Duration) {
+	shuttingDown.Store(true)
+	if delay > 0 {
+		log.Printf("Draining for %v before stopping the server", delay)
+		time.Sleep(delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Failed to wait for in-flight requests: %v", err)
+	}
+	if err := pool.wait(ctx); err != nil {
+		log.Printf("Failed to wait for resource pool allocations: %v", err)
+	}
+	if err := redisClient.Close(); err != nil {
+		log.Printf("Failed to close the redis client: %v", err)
+	}
+	log.Printf("Server stopped")
+}
@@ -0,0 +1,343 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certReloader serves certificate and key pairs read from files, selecting
+// one by the server name (SNI) requested by the client, and reloads them when
+// the files change so that a rotated Kubernetes Secret or cert-manager
+// certificate is picked up without restarting the pod.
+//
+// The pairs are the tlsCert and tlsKey files, if set, and the pairs found in
+// tlsCertDir, if set. See discoverKeyPairs for the layout of the directory.
+//
+// The files are polled rather than watched with inotify: Secret volumes are
+// updated by atomically swapping a symlinked directory, which does not emit
+// events on the file paths themselves.
+type certReloader struct {
+	certPath, keyPath string
+	dir               string
+	defaultName       string
+
+	// pairs is only accessed by reload, which is not called concurrently.
+	pairs map[string]*keyPair
+
+	mtx   sync.RWMutex
+	certs *certSet
+}
+
+// tlsCertPairName is the name of the pair given by the tlsCert and tlsKey
+// settings. It contains a slash so that no pair found in tlsCertDir, which is
+// named after a directory entry, can take its place.
+const tlsCertPairName = "tlsCert/tlsKey"
+
+// newCertReloader loads the certificate and key pairs. Unlike later reloads,
+// the initial load fails if the tlsCert and tlsKey pair or the pair named
+// defaultName cannot be loaded, or if no pair is found at all.
+func newCertReloader(certPath, keyPath, dir, defaultName string) (*certReloader, error) {
+	r := &certReloader{
+		certPath:    certPath,
+		keyPath:     keyPath,
+		dir:         dir,
+		defaultName: defaultName,
+		pairs:       make(map[string]*keyPair),
+	}
+	_, err := r.reload()
+	if r.certPath != "" && r.pairs[tlsCertPairName].cert == nil {
+		return nil, err
+	}
+	if r.defaultName != "" {
+		p, ok := r.pairs[r.defaultName]
+		if !ok {
+			return nil, fmt.Errorf("tlsDefaultCert: no certificate and key pair named %q in %s", r.defaultName, r.dir)
+		}
+		if p.cert == nil {
+			return nil, err
+		}
+	}
+	if len(r.current().names) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.New("no certificate found")
+	}
+	if err != nil {
+		log.Printf("Skipping certificates that failed to load: %v", err)
+	}
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.current().lookup(hello.ServerName), nil
+}
+
+func (r *certReloader) current() *certSet {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.certs
+}
+
+// reload rescans the files and, if any pair was added, removed or changed,
+// replaces the served certificates. It reports whether they were replaced.
+// A pair that fails to load keeps serving its previous certificate, and its
+// error is returned once.
+func (r *certReloader) reload() (bool, error) {
+	found := make(map[string][2]string)
+	if r.certPath != "" {
+		found[tlsCertPairName] = [2]string{r.certPath, r.keyPath}
+	}
+	var errs []error
+	if r.dir != "" {
+		pairs, err := discoverKeyPairs(r.dir)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for name, paths := range pairs {
+			found[name] = paths
+		}
+	}
+
+	changed := r.certs == nil
+	for name, p := range r.pairs {
+		if paths, ok := found[name]; !ok || paths != [2]string{p.certPath, p.keyPath} {
+			delete(r.pairs, name)
+			changed = true
+		}
+	}
+	for name, paths := range found {
+		p, ok := r.pairs[name]
+		if !ok {
+			p = &keyPair{name: name, certPath: paths[0], keyPath: paths[1]}
+			r.pairs[name] = p
+		}
+		pairChanged, err := p.load()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("loading %s certificate: %v", name, err))
+		}
+		changed = changed || pairChanged
+	}
+
+	if changed {
+		certs := newCertSet(r.pairs, r.defaultName)
+		r.mtx.Lock()
+		r.certs = certs
+		r.mtx.Unlock()
+
+		certExpiry.Reset()
+		for _, name := range certs.names {
+			leaf := r.pairs[name].cert.Leaf
+			certExpiry.WithLabelValues(name, leaf.Subject.String()).Set(float64(leaf.NotAfter.Unix()))
+		}
+	}
+	return changed, errors.Join(errs...)
+}
+
+// watch checks the files for changes every interval, forever.
+func (r *certReloader) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		changed, err := r.reload()
+		if err != nil {
+			certReloads.WithLabelValues("failure").Inc()
+			log.Printf("Failed to reload certificates, keeping the previous ones: %v", err)
+		}
+		if changed {
+			certReloads.WithLabelValues("success").Inc()
+		}
+	}
+}
+
+// discoverKeyPairs returns the certificate and key paths found in dir, by
+// name. A pair is either:
+//
+//   - a subdirectory holding tls.crt and tls.key, as when Kubernetes TLS
+//     Secrets are mounted under dir, named after the subdirectory, or
+//   - a NAME.crt file next to a NAME.key file, named NAME.
+//
+// Hidden entries, such as the ..data link of a Secret volume, are ignored.
+func discoverKeyPairs(dir string) (map[string][2]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading certificate directory: %v", err)
+	}
+	pairs := make(map[string][2]string)
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			cert, key := filepath.Join(path, "tls.crt"), filepath.Join(path, "tls.key")
+			if fileExists(cert) && fileExists(key) {
+				pairs[name] = [2]string{cert, key}
+			}
+			continue
+		}
+		if base, ok := strings.CutSuffix(name, ".crt"); ok {
+			if key := filepath.Join(dir, base+".key"); fileExists(key) {
+				pairs[base] = [2]string{path, key}
+			}
+		}
+	}
+	return pairs, nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// keyPair is a certificate and key pair read from files.
+type keyPair struct {
+	name              string
+	certPath, keyPath string
+
+	// cert is the last pair that loaded successfully, or nil.
+	cert            *tls.Certificate
+	certPEM, keyPEM []byte
+
+	// failedCertPEM and failedKeyPEM hold the content that last failed to
+	// parse, so that a broken pair is reported once rather than on every poll.
+	failedCertPEM, failedKeyPEM []byte
+}
+
+// load reads the files and, if their content changed, parses them. It reports
+// whether cert was replaced.
+func (p *keyPair) load() (bool, error) {
+	certPEM, err := os.ReadFile(p.certPath)
+	if err != nil {
+		return false, fmt.Errorf("reading certificate: %v", err)
+	}
+	keyPEM, err := os.ReadFile(p.keyPath)
+	if err != nil {
+		return false, fmt.Errorf("reading key: %v", err)
+	}
+	if p.cert != nil && bytes.Equal(certPEM, p.certPEM) && bytes.Equal(keyPEM, p.keyPEM) ||
+		p.failedCertPEM != nil && bytes.Equal(certPEM, p.failedCertPEM) && bytes.Equal(keyPEM, p.failedKeyPEM) {
+		return false, nil
+	}
+
+	cert, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		p.failedCertPEM, p.failedKeyPEM = certPEM, keyPEM
+		return false, err
+	}
+	p.cert, p.certPEM, p.keyPEM = &cert, certPEM, keyPEM
+	log.Printf("Loaded %s certificate %q for %s, valid until %s",
+		p.name, cert.Leaf.Subject, strings.Join(certNames(cert.Leaf), ", "), cert.Leaf.NotAfter.Format(time.RFC3339))
+	return true, nil
+}
+
+// parseKeyPair parses a PEM encoded certificate and key pair and populates
+// its Leaf.
+func parseKeyPair(certPEM, keyPEM []byte) (tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return cert, fmt.Errorf("parsing certificate and key: %v", err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return cert, fmt.Errorf("parsing certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// certNames returns the lowercase DNS names a certificate is valid for: its
+// DNS SANs, or its common name if it has none.
+func certNames(leaf *x509.Certificate) []string {
+	names := leaf.DNSNames
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = []string{leaf.Subject.CommonName}
+	}
+	lower := make([]string, len(names))
+	for i, n := range names {
+		lower[i] = strings.ToLower(n)
+	}
+	return lower
+}
+
+// certSet is an immutable snapshot of the loaded certificates.
+type certSet struct {
+	// names lists the loaded pairs in order.
+	names []string
+	// byName indexes the certificates by DNS name, including wildcard names
+	// such as "*.example.com".
+	byName map[string]*tls.Certificate
+	// def is served when no certificate matches the requested server name.
+	def *tls.Certificate
+}
+
+// newCertSet indexes the loaded pairs. The default certificate is the
+// tlsCert pair if there is one, then the pair named defaultName, then the
+// first pair by name.
+func newCertSet(pairs map[string]*keyPair, defaultName string) *certSet {
+	s := &certSet{byName: make(map[string]*tls.Certificate)}
+	for name, p := range pairs {
+		if p.cert != nil {
+			s.names = append(s.names, name)
+		}
+	}
+	sort.Strings(s.names)
+	for _, name := range s.names {
+		cert := pairs[name].cert
+		for _, dnsName := range certNames(cert.Leaf) {
+			if _, ok := s.byName[dnsName]; !ok {
+				s.byName[dnsName] = cert
+			}
+		}
+	}
+	for _, name := range []string{tlsCertPairName, defaultName} {
+		if p, ok := pairs[name]; ok && p.cert != nil && s.def == nil {
+			s.def = p.cert
+		}
+	}
+	if s.def == nil && len(s.names) > 0 {
+		s.def = pairs[s.names[0]].cert
+	}
+	return s
+}
+
+// lookup returns the certificate for serverName: an exact match, then a
+// wildcard match, then the default certificate.
+func (s *certSet) lookup(serverName string) *tls.Certificate {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if cert, ok := s.byName[name]; ok {
+		return cert
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := s.byName["*"+name[i:]]; ok {
+			return cert
+		}
+	}
+	return s.def
+}
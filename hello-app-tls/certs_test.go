@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeKeyPair writes a certificate for sans and its key to certPath and
+// keyPath, and returns the certificate.
+func writeKeyPair(t *testing.T, certPath, keyPath string, sans ...string) tls.Certificate {
+	t.Helper()
+	cert, _, err := generateSelfSigned(sans, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCertReloaderDefault(t *testing.T) {
+	dir := t.TempDir()
+	certDir := filepath.Join(dir, "certs")
+	if err := os.Mkdir(certDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeKeyPair(t, certPath, keyPath, "main.example.com")
+	// a pair named like the tlsCert pair used to be must not take its place
+	writeKeyPair(t, filepath.Join(certDir, "default.crt"), filepath.Join(certDir, "default.key"), "default.example.com")
+	writeKeyPair(t, filepath.Join(certDir, "b.crt"), filepath.Join(certDir, "b.key"), "b.example.com")
+	writeKeyPair(t, filepath.Join(certDir, "c.crt"), filepath.Join(certDir, "c.key"), "c.example.com")
+
+	for _, tc := range []struct {
+		name              string
+		certPath, keyPath string
+		defaultName       string
+		want              string
+	}{
+		{"tlsCert first", certPath, keyPath, "c", "main.example.com"},
+		{"tlsDefaultCert", "", "", "c", "c.example.com"},
+		{"tlsDefaultCert named default", "", "", "default", "default.example.com"},
+		{"first by name", "", "", "", "b.example.com"},
+	} {
+		r, err := newCertReloader(tc.certPath, tc.keyPath, certDir, tc.defaultName)
+		if err != nil {
+			t.Errorf("%s: newCertReloader() failed: %v", tc.name, err)
+			continue
+		}
+		for serverName, want := range map[string]string{
+			"":               tc.want,
+			"unknown.test":   tc.want,
+			"B.example.com.": "b.example.com",
+		} {
+			cert, _ := r.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+			if got := cert.Leaf.Subject.CommonName; got != want {
+				t.Errorf("%s: certificate for %q is %q, want %q", tc.name, serverName, got, want)
+			}
+		}
+	}
+}
+
+func TestCertReloaderUnknownDefault(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyPair(t, filepath.Join(dir, "a.crt"), filepath.Join(dir, "a.key"), "a.example.com")
+	_, err := newCertReloader("", "", dir, "missing")
+	if err == nil || !strings.Contains(err.Error(), `"missing"`) {
+		t.Errorf("newCertReloader() with an unknown default = %v, want an error naming it", err)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// clientAuthModes maps the values of the tlsClientAuth setting to the client
+// certificate policy of the server:
+//
+//	none     do not ask for a client certificate
+//	request  ask for a certificate, but do not require or verify it
+//	require  require a certificate, but do not verify it
+//	verify   require a certificate signed by tlsClientCA
+var clientAuthModes = map[string]tls.ClientAuthType{
+	"none":    tls.NoClientCert,
+	"request": tls.RequestClientCert,
+	"require": tls.RequireAnyClientCert,
+	"verify":  tls.RequireAndVerifyClientCert,
+}
+
+// clientAuthMode returns the mode to use for the tlsClientAuth setting: the
+// setting itself, or verify if it is empty and a CA bundle is configured.
+func clientAuthMode(mode, caPath string) string {
+	if mode != "" {
+		return mode
+	}
+	if caPath != "" {
+		return "verify"
+	}
+	return "none"
+}
+
+// loadClientCAs reads a PEM bundle of the CAs that sign client certificates.
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA bundle: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA bundle")
+	}
+	return pool, nil
+}
+
+// writeClientCert reports the client certificate of the request, if any: its
+// subject, SANs, SPIFFE ID and whether it was verified against the client CAs.
+func writeClientCert(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return
+	}
+	cert := r.TLS.PeerCertificates[0]
+	fmt.Fprintf(w, "Client certificate: %s\n", cert.Subject)
+	if sans := subjectAltNames(cert); len(sans) > 0 {
+		fmt.Fprintf(w, "Client certificate SANs: %s\n", strings.Join(sans, ", "))
+	}
+	if id := spiffeID(cert); id != "" {
+		fmt.Fprintf(w, "Client SPIFFE ID: %s\n", id)
+	}
+	fmt.Fprintf(w, "Client certificate verified: %t\n", len(r.TLS.VerifiedChains) > 0)
+}
+
+func subjectAltNames(cert *x509.Certificate) []string {
+	var sans []string
+	for _, name := range cert.DNSNames {
+		sans = append(sans, "DNS:"+name)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+	return sans
+}
+
+// spiffeID returns the SPIFFE ID of cert, which is its only URI SAN with the
+// spiffe scheme, or an empty string.
+func spiffeID(cert *x509.Certificate) string {
+	var id string
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			if id != "" {
+				return ""
+			}
+			id = uri.String()
+		}
+	}
+	return id
+}
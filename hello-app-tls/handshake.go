@@ -0,0 +1,139 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// handshakeTracker records the outcome of TLS handshakes: the duration of
+// successful ones, and the client, server name and reason of failed ones.
+//
+// Failures are reported by the HTTP server through its ErrorLog, which is
+// only given the client address and the error, so the server name requested
+// in the ClientHello is kept by client address until the connection closes.
+// Some failures, such as a TLS 1.3 client rejecting the server certificate,
+// are only detected once the server considers the handshake complete.
+type handshakeTracker struct {
+	mtx sync.Mutex
+	sni map[string]string
+}
+
+func newHandshakeTracker() *handshakeTracker {
+	return &handshakeTracker{sni: make(map[string]string)}
+}
+
+// instrument makes config report its handshakes to the tracker. It must be
+// called once config is otherwise complete.
+func (t *handshakeTracker) instrument(config *tls.Config) {
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		start := time.Now()
+		addr := hello.Conn.RemoteAddr().String()
+		t.mtx.Lock()
+		t.sni[addr] = hello.ServerName
+		t.mtx.Unlock()
+
+		// The session ticket keys of config are still used for the clone.
+		c := config.Clone()
+		c.VerifyConnection = func(cs tls.ConnectionState) error {
+			handshakeDuration.WithLabelValues(tls.VersionName(cs.Version)).Observe(time.Since(start).Seconds())
+			return nil
+		}
+		return c, nil
+	}
+}
+
+// connState forgets closed connections. It implements http.Server.ConnState.
+func (t *handshakeTracker) connState(c net.Conn, state http.ConnState) {
+	if state == http.StateClosed || state == http.StateHijacked {
+		t.done(c.RemoteAddr().String())
+	}
+}
+
+// done forgets the connection from addr and returns the server name it
+// requested, if any.
+func (t *handshakeTracker) done(addr string) string {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	sni := t.sni[addr]
+	delete(t.sni, addr)
+	return sni
+}
+
+const handshakeErrorPrefix = "http: TLS handshake error from "
+
+// Write receives the messages of the HTTP server's ErrorLog. Handshake errors
+// are counted and logged with their client, server name and reason; other
+// messages are logged as is.
+func (t *handshakeTracker) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	rest, ok := strings.CutPrefix(msg, handshakeErrorPrefix)
+	if !ok {
+		log.Print(msg)
+		return len(p), nil
+	}
+	addr, err, _ := strings.Cut(rest, ": ")
+	clientIP, _, splitErr := net.SplitHostPort(addr)
+	if splitErr != nil {
+		clientIP = addr
+	}
+	reason := handshakeFailureReason(err)
+	handshakeErrors.WithLabelValues(reason).Inc()
+	log.Printf("TLS handshake failed: client_ip=%s sni=%q reason=%s error=%q", clientIP, t.done(addr), reason, err)
+	return len(p), nil
+}
+
+// handshakeFailureReasons maps substrings of handshake errors to the reason
+// reported for them, in order of precedence.
+var handshakeFailureReasons = []struct {
+	substr, reason string
+}{
+	{"reading PROXY protocol header", "proxy_protocol"},
+	{"first record does not look like a TLS handshake", "not_tls"},
+	{"unsupported versions", "no_common_version"},
+	{"older than TLS 1.3", "no_common_version"},
+	{"inappropriate protocol fallback", "no_common_version"},
+	{"no cipher suite supported", "no_common_cipher_suite"},
+	{"curve supported", "no_common_curve"},
+	{"key exchanges supported", "no_common_curve"},
+	{"application protocol", "no_common_alpn"},
+	{"client didn't provide a certificate", "client_certificate_missing"},
+	{"client certificate", "client_certificate_invalid"},
+	{"failed to verify certificate", "client_certificate_invalid"},
+	{"x509:", "client_certificate_invalid"},
+	// an alert sent by the client, usually because it rejected the server
+	// certificate
+	{"remote error:", "client_alert"},
+	{"timeout", "timeout"},
+	{"EOF", "connection_closed"},
+	{"connection reset", "connection_closed"},
+}
+
+// handshakeFailureReason classifies a handshake error for the
+// hello_app_tls_handshake_errors_total metric.
+func handshakeFailureReason(err string) string {
+	for _, r := range handshakeFailureReasons {
+		if strings.Contains(err, r.substr) {
+			return r.reason
+		}
+	}
+	return "other"
+}
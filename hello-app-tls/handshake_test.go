@@ -0,0 +1,143 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHandshakeFailureReasons(t *testing.T) {
+	serverCert, caPEM, err := generateSelfSigned([]string{"localhost"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	otherCert, _, err := generateSelfSigned([]string{"client"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		server *tls.Config
+		client *tls.Config
+		reason string
+	}{
+		{
+			name:   "wrong SNI",
+			server: &tls.Config{},
+			client: &tls.Config{ServerName: "other.example.com", RootCAs: roots},
+			reason: "client_alert",
+		},
+		{
+			name:   "protocol version mismatch",
+			server: &tls.Config{MaxVersion: tls.VersionTLS12},
+			client: &tls.Config{ServerName: "localhost", RootCAs: roots, MinVersion: tls.VersionTLS13},
+			reason: "no_common_version",
+		},
+		{
+			name: "no shared cipher suite",
+			server: &tls.Config{
+				MaxVersion:   tls.VersionTLS12,
+				CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+			},
+			client: &tls.Config{
+				ServerName:   "localhost",
+				RootCAs:      roots,
+				MaxVersion:   tls.VersionTLS12,
+				CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			},
+			reason: "no_common_cipher_suite",
+		},
+		{
+			name:   "missing client certificate",
+			server: &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: roots},
+			client: &tls.Config{ServerName: "localhost", RootCAs: roots},
+			reason: "client_certificate_missing",
+		},
+		{
+			name:   "untrusted client certificate",
+			server: &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: roots},
+			client: &tls.Config{ServerName: "localhost", RootCAs: roots, Certificates: []tls.Certificate{otherCert}},
+			reason: "client_certificate_invalid",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := startHandshakeServer(t, serverCert, tc.server)
+			before := testutil.ToFloat64(handshakeErrors.WithLabelValues(tc.reason))
+			if conn, err := tls.Dial("tcp", addr, tc.client); err == nil {
+				// TLS 1.3 clients only learn that their certificate was
+				// rejected when they read from the connection
+				_, err = conn.Read(make([]byte, 1))
+				conn.Close()
+				if err == nil {
+					t.Fatal("handshake succeeded")
+				}
+			}
+			waitForCount(t, tc.reason, before+1)
+		})
+	}
+
+	t.Run("not TLS", func(t *testing.T) {
+		addr := startHandshakeServer(t, serverCert, &tls.Config{})
+		before := testutil.ToFloat64(handshakeErrors.WithLabelValues("not_tls"))
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.Write([]byte("\x00\x01\x02\x03\x04\x05\x06\x07"))
+		io.Copy(io.Discard, conn)
+		waitForCount(t, "not_tls", before+1)
+	})
+}
+
+// startHandshakeServer starts a TLS server with the given settings and the
+// handshake tracker, and returns its address.
+func startHandshakeServer(t *testing.T, cert tls.Certificate, config *tls.Config) string {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(hello))
+	ts.TLS = config.Clone()
+	ts.TLS.Certificates = []tls.Certificate{cert}
+	handshakes := newHandshakeTracker()
+	handshakes.instrument(ts.TLS)
+	ts.Config.ErrorLog = log.New(handshakes, "", 0)
+	ts.Config.ConnState = handshakes.connState
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts.Listener.Addr().String()
+}
+
+// waitForCount waits for the handshake errors with reason to reach want.
+func waitForCount(t *testing.T, reason string, want float64) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if testutil.ToFloat64(handshakeErrors.WithLabelValues(reason)) >= want {
+			return
+		}
+	}
+	t.Errorf("no handshake error counted with reason %s", reason)
+}
@@ -15,35 +15,235 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
+// config holds the settings of the server. They are loaded from a YAML file,
+// environment variables and flags by loadConfig.
+type config struct {
+	Port    string `yaml:"port" env:"PORT" usage:"port to serve HTTPS on"`
+	TLSCert string `yaml:"tlsCert" env:"TLS_CERT" usage:"path of the TLS certificate file"`
+	TLSKey  string `yaml:"tlsKey" env:"TLS_KEY" usage:"path of the TLS private key file"`
+
+	TLSCertDir     string `yaml:"tlsCertDir" env:"TLS_CERT_DIR" usage:"directory of certificate and key pairs to select from by SNI"`
+	TLSDefaultCert string `yaml:"tlsDefaultCert" env:"TLS_DEFAULT_CERT" usage:"name of the pair in tlsCertDir to serve when no certificate matches the SNI"`
+
+	TLSSelfSigned         bool          `yaml:"tlsSelfSigned" env:"TLS_SELF_SIGNED" usage:"serve an ephemeral certificate signed by an ephemeral CA instead of tlsCert or tlsCertDir"`
+	TLSSelfSignedSANs     []string      `yaml:"tlsSelfSignedSANs" env:"TLS_SELF_SIGNED_SANS" usage:"comma-separated DNS names and IPs of the self-signed certificate (default localhost, 127.0.0.1, ::1 and the hostname)"`
+	TLSSelfSignedValidity time.Duration `yaml:"tlsSelfSignedValidity" env:"TLS_SELF_SIGNED_VALIDITY" usage:"validity of the self-signed certificate"`
+	TLSSelfSignedCAOut    string        `yaml:"tlsSelfSignedCAOut" env:"TLS_SELF_SIGNED_CA_OUT" usage:"path to write the PEM encoded self-signed CA certificate to, for clients to trust"`
+
+	TLSClientCA   string `yaml:"tlsClientCA" env:"TLS_CLIENT_CA" usage:"path of a PEM bundle of CAs that sign client certificates"`
+	TLSClientAuth string `yaml:"tlsClientAuth" env:"TLS_CLIENT_AUTH" usage:"client certificate mode: none, request, require or verify (default verify if tlsClientCA is set, none otherwise)"`
+
+	TLSMinVersion       string   `yaml:"tlsMinVersion" env:"TLS_MIN_VERSION" usage:"minimum TLS version: 1.0, 1.1, 1.2 or 1.3"`
+	TLSMaxVersion       string   `yaml:"tlsMaxVersion" env:"TLS_MAX_VERSION" usage:"maximum TLS version: 1.0, 1.1, 1.2 or 1.3"`
+	TLSCipherSuites     []string `yaml:"tlsCipherSuites" env:"TLS_CIPHER_SUITES" usage:"comma-separated TLS 1.0-1.2 cipher suites, by IANA name"`
+	TLSCurvePreferences []string `yaml:"tlsCurvePreferences" env:"TLS_CURVE_PREFERENCES" usage:"comma-separated key exchange curves: X25519, P256, P384, P521"`
+	TLSALPN             []string `yaml:"tlsALPN" env:"TLS_ALPN" usage:"comma-separated ALPN protocols, e.g. h2,http/1.1"`
+
+	RedirectPort      string `yaml:"redirectPort" env:"REDIRECT_PORT" usage:"port to serve plaintext HTTP redirects to HTTPS on (disabled if empty)"`
+	RedirectCode      int    `yaml:"redirectCode" env:"REDIRECT_CODE" usage:"status code of the redirects: 301 or 308"`
+	RedirectHTTPSPort string `yaml:"redirectHTTPSPort" env:"REDIRECT_HTTPS_PORT" usage:"HTTPS port to redirect to, as seen by clients (default port)"`
+
+	HSTSMaxAge            time.Duration `yaml:"hstsMaxAge" env:"HSTS_MAX_AGE" usage:"max-age of the Strict-Transport-Security header, e.g. 8760h (disabled if zero)"`
+	HSTSIncludeSubdomains bool          `yaml:"hstsIncludeSubdomains" env:"HSTS_INCLUDE_SUBDOMAINS" usage:"add includeSubDomains to the Strict-Transport-Security header"`
+	HSTSPreload           bool          `yaml:"hstsPreload" env:"HSTS_PRELOAD" usage:"add preload to the Strict-Transport-Security header"`
+
+	ProxyProtocol             bool     `yaml:"proxyProtocol" env:"PROXY_PROTOCOL" usage:"accept PROXY protocol v1 and v2 headers on the HTTPS port"`
+	ProxyProtocolTrustedCIDRs []string `yaml:"proxyProtocolTrustedCIDRs" env:"PROXY_PROTOCOL_TRUSTED_CIDRS" usage:"comma-separated CIDRs of the proxies allowed to send PROXY protocol headers, required with proxyProtocol"`
+
+	CertReloadInterval time.Duration `yaml:"certReloadInterval" env:"CERT_RELOAD_INTERVAL" usage:"how often to check the certificate files for changes"`
+	MetricsPort        string        `yaml:"metricsPort" env:"METRICS_PORT" usage:"port to serve Prometheus metrics on"`
+}
+
+func (c *config) validate() error {
+	if err := validatePort("port", c.Port); err != nil {
+		return err
+	}
+	if c.TLSSelfSigned {
+		if c.TLSCert != "" || c.TLSKey != "" || c.TLSCertDir != "" {
+			return errors.New("tlsSelfSigned cannot be combined with tlsCert, tlsKey or tlsCertDir")
+		}
+		if c.TLSSelfSignedValidity <= 0 {
+			return errors.New("tlsSelfSignedValidity must be positive")
+		}
+	} else if c.TLSCert == "" && c.TLSKey == "" && c.TLSCertDir == "" {
+		return errors.New("tlsCert and tlsKey (TLS_CERT and TLS_KEY environment variables), tlsCertDir or tlsSelfSigned must be set")
+	}
+	if c.TLSCert == "" && c.TLSKey != "" {
+		return errors.New("tlsCert (TLS_CERT environment variable) must be set")
+	}
+	if c.TLSKey == "" && c.TLSCert != "" {
+		return errors.New("tlsKey (TLS_KEY environment variable) must be set")
+	}
+	if c.TLSDefaultCert != "" && c.TLSCertDir == "" {
+		return errors.New("tlsCertDir must be set with tlsDefaultCert")
+	}
+	mode := clientAuthMode(c.TLSClientAuth, c.TLSClientCA)
+	if _, ok := clientAuthModes[mode]; !ok {
+		return fmt.Errorf("tlsClientAuth must be none, request, require or verify, got %q", mode)
+	}
+	if mode == "verify" && c.TLSClientCA == "" {
+		return errors.New("tlsClientCA must be set when tlsClientAuth is verify")
+	}
+	if err := c.tlsPolicy().apply(&tls.Config{}); err != nil {
+		return err
+	}
+	if c.RedirectPort != "" {
+		if err := validatePort("redirectPort", c.RedirectPort); err != nil {
+			return err
+		}
+		if c.RedirectPort == c.Port || c.RedirectPort == c.MetricsPort {
+			return errors.New("redirectPort must be different from port and metricsPort")
+		}
+		if c.RedirectCode != http.StatusMovedPermanently && c.RedirectCode != http.StatusPermanentRedirect {
+			return fmt.Errorf("redirectCode must be 301 or 308, got %d", c.RedirectCode)
+		}
+		if c.RedirectHTTPSPort != "" {
+			if err := validatePort("redirectHTTPSPort", c.RedirectHTTPSPort); err != nil {
+				return err
+			}
+		}
+	}
+	if c.HSTSMaxAge < 0 {
+		return errors.New("hstsMaxAge must not be negative")
+	}
+	if (c.HSTSIncludeSubdomains || c.HSTSPreload) && c.HSTSMaxAge == 0 {
+		return errors.New("hstsMaxAge must be set with hstsIncludeSubdomains or hstsPreload")
+	}
+	if _, err := parseCIDRs(c.ProxyProtocolTrustedCIDRs); err != nil {
+		return fmt.Errorf("proxyProtocolTrustedCIDRs: %v", err)
+	}
+	if c.ProxyProtocol && len(c.ProxyProtocolTrustedCIDRs) == 0 {
+		return errors.New("proxyProtocolTrustedCIDRs must be set with proxyProtocol, so that clients cannot spoof their address")
+	}
+	if c.CertReloadInterval <= 0 {
+		return errors.New("certReloadInterval must be positive")
+	}
+	if err := validatePort("metricsPort", c.MetricsPort); err != nil {
+		return err
+	}
+	if c.Port == c.MetricsPort {
+		return errors.New("port and metricsPort must be different")
+	}
+	return nil
+}
+
+func (c *config) tlsPolicy() tlsPolicy {
+	return tlsPolicy{
+		MinVersion:       c.TLSMinVersion,
+		MaxVersion:       c.TLSMaxVersion,
+		CipherSuites:     c.TLSCipherSuites,
+		CurvePreferences: c.TLSCurvePreferences,
+		ALPN:             c.TLSALPN,
+	}
+}
+
 func main() {
-	port := "8443"
-	if fromEnv := os.Getenv("PORT"); fromEnv != "" {
-		port = fromEnv
+	cfg := config{
+		Port:                  "8443",
+		TLSSelfSignedValidity: 24 * time.Hour,
+		RedirectCode:          http.StatusPermanentRedirect,
+		CertReloadInterval:    10 * time.Second,
+		MetricsPort:           "9090",
 	}
-	tlsCert, tlsKey := os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY")
-	if tlsCert == "" {
-		log.Fatal("TLS_CERT environment variable must be set")
+	if err := loadConfig(&cfg); err != nil {
+		log.Fatal(err)
 	}
-	if tlsKey == "" {
-		log.Fatal("TLS_KEY environment variable must be set")
+
+	// serve either an ephemeral self-signed certificate, or the certificates
+	// read from files, selected by SNI and reloaded when the files change
+	tlsConfig := &tls.Config{}
+	if cfg.TLSSelfSigned {
+		cert, err := bootstrapSelfSigned(cfg.TLSSelfSignedSANs, cfg.TLSSelfSignedValidity, cfg.TLSSelfSignedCAOut)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+	} else {
+		certs, err := newCertReloader(cfg.TLSCert, cfg.TLSKey, cfg.TLSCertDir, cfg.TLSDefaultCert)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go certs.watch(cfg.CertReloadInterval)
+		tlsConfig.GetCertificate = certs.GetCertificate
 	}
+	go serveMetrics(cfg.MetricsPort)
+
+	// restrict the protocol versions, cipher suites, curves and ALPN protocols
+	policy := cfg.tlsPolicy()
+	if err := policy.apply(tlsConfig); err != nil {
+		log.Fatal(err)
+	}
+
+	// ask clients for a certificate, and verify it against the client CAs
+	mode := clientAuthMode(cfg.TLSClientAuth, cfg.TLSClientCA)
+	tlsConfig.ClientAuth = clientAuthModes[mode]
+	if cfg.TLSClientCA != "" {
+		cas, err := loadClientCAs(cfg.TLSClientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tlsConfig.ClientCAs = cas
+	}
+	log.Printf("Client certificate mode: %s", mode)
+
+	// count and log failed handshakes, and time successful ones
+	handshakes := newHandshakeTracker()
+	handshakes.instrument(tlsConfig)
 
 	// register hello function to handle all requests
-	server := http.NewServeMux()
-	server.HandleFunc("/", hello)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", hello)
+	hsts := hstsHeader(cfg.HSTSMaxAge, cfg.HSTSIncludeSubdomains, cfg.HSTSPreload)
+	server := &http.Server{
+		Addr:        ":" + cfg.Port,
+		Handler:     withHSTS(mux, hsts),
+		TLSConfig:   tlsConfig,
+		ConnContext: saveConn,
+		ErrorLog:    log.New(handshakes, "", 0),
+		ConnState:   handshakes.connState,
+	}
+	policy.configureServer(server)
+	if policy.disablesHTTP2() {
+		log.Printf("HTTP/2 is disabled by the ALPN protocols or cipher suites")
+	}
+
+	// redirect plaintext HTTP requests to the HTTPS server
+	if cfg.RedirectPort != "" {
+		httpsPort := cfg.RedirectHTTPSPort
+		if httpsPort == "" {
+			httpsPort = cfg.Port
+		}
+		go func() {
+			log.Printf("Redirecting HTTP on port %s to HTTPS port %s", cfg.RedirectPort, httpsPort)
+			err := http.ListenAndServe(":"+cfg.RedirectPort, redirectHandler(httpsPort, cfg.RedirectCode))
+			log.Fatal(err)
+		}()
+	}
 
 	// start the web server on port and accept requests
-	log.Printf("tls cert: %s", tlsCert)
-	log.Printf("tls key: %s", tlsKey)
-	log.Printf("Server listening on port %s", port)
-	err := http.ListenAndServeTLS(":"+port, tlsCert, tlsKey, server)
-	log.Fatal(err)
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.ProxyProtocol {
+		// already validated
+		trusted, _ := parseCIDRs(cfg.ProxyProtocolTrustedCIDRs)
+		ln = &proxyListener{Listener: ln, trusted: trusted}
+		log.Printf("Accepting PROXY protocol headers from %s", strings.Join(cfg.ProxyProtocolTrustedCIDRs, ", "))
+	}
+	log.Printf("Server listening on port %s", cfg.Port)
+	log.Fatal(server.ServeTLS(ln, "", ""))
 }
 
 // hello responds to the request with a plain-text "Hello, world" message.
@@ -53,7 +253,10 @@ func hello(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Hello, world!\n")
 	fmt.Fprintf(w, "Protocol: %s!\n", r.Proto)
 	fmt.Fprintf(w, "Hostname: %s\n", host)
+	writeClientAddr(w, r)
 	if headerIP := r.Header.Get("X-Forwarded-For"); headerIP != "" {
 		fmt.Fprintf(w, "Client IP (X-Forwarded-For): %s\n", headerIP)
 	}
+	writeTLSDetails(w, r)
+	writeClientCert(w, r)
 }
@@ -0,0 +1,65 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	reg        = prometheus.NewRegistry()
+	certExpiry = promauto.With(reg).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hello_app_tls_certificate_expiry_timestamp_seconds",
+			Help: "Expiry time of the serving certificates as a Unix timestamp, by certificate name and subject.",
+		},
+		[]string{"name", "subject"},
+	)
+	certReloads = promauto.With(reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hello_app_tls_certificate_reloads_total",
+			Help: "Number of times the serving certificates were reloaded, by result.",
+		},
+		[]string{"result"},
+	)
+	handshakeErrors = promauto.With(reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hello_app_tls_handshake_errors_total",
+			Help: "Number of failed TLS handshakes, by reason.",
+		},
+		[]string{"reason"},
+	)
+	handshakeDuration = promauto.With(reg).NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "hello_app_tls_handshake_duration_seconds",
+			Help:    "Duration of successful TLS handshakes from the ClientHello, by TLS version.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		},
+		[]string{"version"},
+	)
+)
+
+// serveMetrics exposes the metrics over plain HTTP on their own port.
+func serveMetrics(port string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	log.Printf("Metrics listening on port %s", port)
+	log.Fatal(http.ListenAndServe(":"+port, mux))
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// redirectHandler redirects every request to the same host, path and query
+// over HTTPS, with the given status code. httpsPort is the port clients reach
+// the HTTPS server on, which is left out of the redirect when it is 443.
+// Requests without a host are rejected.
+func redirectHandler(httpsPort string, code int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			// an HTTP/1.0 request without a Host header has nowhere to go
+			http.Error(w, "400 Bad Request: missing Host header", http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(host, ":") {
+			// an IPv6 literal, which SplitHostPort returns unbracketed
+			host = "[" + strings.Trim(host, "[]") + "]"
+		}
+		if httpsPort != "443" {
+			host += ":" + httpsPort
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		log.Printf("Redirecting request: %s to %s", r.URL.Path, target)
+		http.Redirect(w, r, target, code)
+	})
+}
+
+// hstsHeader returns the Strict-Transport-Security header value for the
+// given settings, or "" if maxAge is zero.
+func hstsHeader(maxAge time.Duration, includeSubdomains, preload bool) string {
+	if maxAge <= 0 {
+		return ""
+	}
+	v := fmt.Sprintf("max-age=%d", int64(maxAge/time.Second))
+	if includeSubdomains {
+		v += "; includeSubDomains"
+	}
+	if preload {
+		v += "; preload"
+	}
+	return v
+}
+
+// withHSTS sets the Strict-Transport-Security header to value on the
+// responses of h. Browsers ignore the header on plaintext responses, so it is
+// only added to the HTTPS server.
+func withHSTS(h http.Handler, value string) http.Handler {
+	if value == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,44 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	for _, tc := range []struct {
+		host, httpsPort string
+		code            int
+		wantCode        int
+		location        string
+	}{
+		{"example.com", "443", 308, 308, "https://example.com/path?q=1"},
+		{"example.com:8080", "8443", 301, 301, "https://example.com:8443/path?q=1"},
+		{"[2001:db8::1]:8080", "443", 308, 308, "https://[2001:db8::1]/path?q=1"},
+		{"", "443", 308, http.StatusBadRequest, ""},
+		{":8080", "443", 308, http.StatusBadRequest, ""},
+	} {
+		r := httptest.NewRequest("GET", "/path?q=1", nil)
+		r.Host = tc.host
+		w := httptest.NewRecorder()
+		redirectHandler(tc.httpsPort, tc.code).ServeHTTP(w, r)
+		if w.Code != tc.wantCode || w.Header().Get("Location") != tc.location {
+			t.Errorf("host %q: status %d, Location %q, want %d, %q", tc.host, w.Code, w.Header().Get("Location"), tc.wantCode, tc.location)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultSelfSignedSANs returns the names the self-signed certificate is
+// valid for when tlsSelfSignedSANs is not set.
+func defaultSelfSignedSANs() []string {
+	sans := []string{"localhost", "127.0.0.1", "::1"}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		sans = append(sans, host)
+	}
+	return sans
+}
+
+// bootstrapSelfSigned generates the self-signed certificate served when
+// tlsSelfSigned is true, and writes the CA certificate to caOut if it is set.
+func bootstrapSelfSigned(sans []string, validity time.Duration, caOut string) (*tls.Certificate, error) {
+	if len(sans) == 0 {
+		sans = defaultSelfSignedSANs()
+	}
+	cert, caPEM, err := generateSelfSigned(sans, validity)
+	if err != nil {
+		return nil, fmt.Errorf("generating self-signed certificate: %v", err)
+	}
+	if caOut != "" {
+		if err := os.WriteFile(caOut, caPEM, 0644); err != nil {
+			return nil, fmt.Errorf("writing CA certificate: %v", err)
+		}
+		log.Printf("Wrote self-signed CA certificate to %s", caOut)
+	}
+	certExpiry.WithLabelValues("self-signed", cert.Leaf.Subject.String()).Set(float64(cert.Leaf.NotAfter.Unix()))
+	log.Printf("Generated self-signed certificate for %s, valid until %s",
+		strings.Join(sans, ", "), cert.Leaf.NotAfter.Format(time.RFC3339))
+	return &cert, nil
+}
+
+// generateSelfSigned creates an ephemeral CA and a certificate for sans
+// signed by it, both valid for the given duration. It returns the certificate
+// chain and key, and the PEM encoded CA certificate that clients can trust.
+// Nothing is written to disk.
+func generateSelfSigned(sans []string, validity time.Duration) (tls.Certificate, []byte, error) {
+	notBefore := time.Now().Add(-time.Minute)
+	notAfter := notBefore.Add(validity)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "hello-app-tls ephemeral CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("creating CA certificate: %v", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: sans[0]},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, san)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("creating certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{der, caDER},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	return cert, caPEM, nil
+}
+
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+	return serial
+}
@@ -0,0 +1,163 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// tlsVersions maps the values of the tlsMinVersion and tlsMaxVersion
+// settings to TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// curves maps the values of the tlsCurvePreferences setting to curves.
+var curves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// tlsPolicy is the protocol policy of the server. Empty fields keep the Go
+// defaults.
+type tlsPolicy struct {
+	MinVersion       string
+	MaxVersion       string
+	CipherSuites     []string
+	CurvePreferences []string
+	ALPN             []string
+}
+
+// apply sets the policy on config.
+func (p tlsPolicy) apply(config *tls.Config) error {
+	var err error
+	if config.MinVersion, err = parseTLSVersion(p.MinVersion); err != nil {
+		return fmt.Errorf("tlsMinVersion: %v", err)
+	}
+	if config.MaxVersion, err = parseTLSVersion(p.MaxVersion); err != nil {
+		return fmt.Errorf("tlsMaxVersion: %v", err)
+	}
+	if config.MinVersion != 0 && config.MaxVersion != 0 && config.MinVersion > config.MaxVersion {
+		return fmt.Errorf("tlsMinVersion %s is greater than tlsMaxVersion %s", p.MinVersion, p.MaxVersion)
+	}
+	if config.CipherSuites, err = parseCipherSuites(p.CipherSuites); err != nil {
+		return fmt.Errorf("tlsCipherSuites: %v", err)
+	}
+	for _, name := range p.CurvePreferences {
+		id, ok := curves[name]
+		if !ok {
+			return fmt.Errorf("tlsCurvePreferences: unknown curve %q, must be one of X25519, P256, P384 or P521", name)
+		}
+		config.CurvePreferences = append(config.CurvePreferences, id)
+	}
+	config.NextProtos = p.ALPN
+	return nil
+}
+
+// disablesHTTP2 reports whether the policy rules out HTTP/2, in which case the
+// HTTP server must not negotiate it on its own: either the ALPN protocols are
+// set and leave out h2, or the cipher suites allow TLS 1.2 without one of the
+// AES_128_GCM_SHA256 suites that HTTP/2 requires, which would keep the HTTP/2
+// server from starting.
+func (p tlsPolicy) disablesHTTP2() bool {
+	if len(p.ALPN) > 0 && !slices.Contains(p.ALPN, "h2") {
+		return true
+	}
+	if len(p.CipherSuites) == 0 {
+		return false
+	}
+	if minVersion, _ := parseTLSVersion(p.MinVersion); minVersion == tls.VersionTLS13 {
+		return false
+	}
+	return !slices.Contains(p.CipherSuites, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256") &&
+		!slices.Contains(p.CipherSuites, "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+}
+
+// configureServer sets the ALPN protocols of the server's TLS config, which
+// must already be instrumented by the handshake tracker: the server only adds
+// h2 and http/1.1 to its own copy of the config, which the handshakes do not
+// use anymore.
+func (p tlsPolicy) configureServer(server *http.Server) {
+	config := server.TLSConfig
+	if p.disablesHTTP2() {
+		config.NextProtos = slices.DeleteFunc(slices.Clone(config.NextProtos), func(proto string) bool {
+			return proto == "h2"
+		})
+		// a non-nil map keeps the server from adding h2 to the ALPN protocols
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	} else if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{"h2"}
+	}
+	if !slices.Contains(config.NextProtos, "http/1.1") {
+		config.NextProtos = append(config.NextProtos, "http/1.1")
+	}
+}
+
+func parseTLSVersion(v string) (uint16, error) {
+	if v == "" {
+		return 0, nil
+	}
+	version, ok := tlsVersions[strings.TrimPrefix(strings.ToUpper(v), "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, must be one of 1.0, 1.1, 1.2 or 1.3", v)
+	}
+	return version, nil
+}
+
+// parseCipherSuites looks up cipher suites by their IANA name, such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Insecure suites are accepted so that
+// weak load balancer policies can be reproduced.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[cs.Name] = cs.ID
+	}
+	var ids []uint16
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// writeTLSDetails reports the negotiated parameters of the TLS connection.
+func writeTLSDetails(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil {
+		return
+	}
+	fmt.Fprintf(w, "TLS version: %s\n", tls.VersionName(r.TLS.Version))
+	fmt.Fprintf(w, "TLS cipher suite: %s\n", tls.CipherSuiteName(r.TLS.CipherSuite))
+	if r.TLS.NegotiatedProtocol != "" {
+		fmt.Fprintf(w, "TLS ALPN protocol: %s\n", r.TLS.NegotiatedProtocol)
+	}
+	if r.TLS.ServerName != "" {
+		fmt.Fprintf(w, "TLS server name (SNI): %s\n", r.TLS.ServerName)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startServer serves hello over TLS on a local port with the given policy, set
+// up the way main does, and returns its address.
+func startServer(t *testing.T, policy tlsPolicy) string {
+	t.Helper()
+	cert, _, err := generateSelfSigned([]string{"localhost"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if err := policy.apply(tlsConfig); err != nil {
+		t.Fatal(err)
+	}
+	handshakes := newHandshakeTracker()
+	handshakes.instrument(tlsConfig)
+	server := &http.Server{
+		Handler:   http.HandlerFunc(hello),
+		TLSConfig: tlsConfig,
+		ErrorLog:  log.New(handshakes, "", 0),
+		ConnState: handshakes.connState,
+	}
+	policy.configureServer(server)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- server.ServeTLS(ln, "", "") }()
+	t.Cleanup(func() { server.Close() })
+	select {
+	case err := <-errc:
+		t.Fatalf("ServeTLS() failed: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	return ln.Addr().String()
+}
+
+func TestServerHTTP2(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy tlsPolicy
+		proto  string
+	}{
+		{"default", tlsPolicy{}, "HTTP/2.0"},
+		{"ALPN without h2", tlsPolicy{ALPN: []string{"http/1.1"}}, "HTTP/1.1"},
+		{"ALPN with h2", tlsPolicy{ALPN: []string{"h2"}}, "HTTP/2.0"},
+		{
+			"cipher suites with an HTTP/2 suite",
+			tlsPolicy{CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}},
+			"HTTP/2.0",
+		},
+		{
+			"cipher suites without an HTTP/2 suite",
+			tlsPolicy{CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}},
+			"HTTP/1.1",
+		},
+		{
+			"TLS 1.2 cipher suites without an HTTP/2 suite and h2",
+			tlsPolicy{MaxVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}, ALPN: []string{"h2", "http/1.1"}},
+			"HTTP/1.1",
+		},
+		{
+			"cipher suites ignored by TLS 1.3",
+			tlsPolicy{MinVersion: "1.3", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}},
+			"HTTP/2.0",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := startServer(t, tc.policy)
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				ForceAttemptHTTP2: true,
+			}}
+			resp, err := client.Get("https://" + addr + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.Proto != tc.proto {
+				t.Errorf("response protocol is %s, want %s", resp.Proto, tc.proto)
+			}
+		})
+	}
+}
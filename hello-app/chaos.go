@@ -0,0 +1,390 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fault injection for resilience demos. It is disabled unless the chaos
+// setting is true, and every fault is capped by the limits in chaosLimits.
+//
+// Latency and errors can be injected into a single request with query
+// parameters, e.g. "/?latency=2s&error_rate=0.5&error_code=503", or for all
+// requests through the admin API, which is served on the metrics port so that
+// it is not exposed through the Service:
+//
+//	GET    /chaos         show the current faults and limits
+//	PUT    /chaos         set faults from a JSON body, e.g. {"latency":"1s","errorRate":0.1}
+//	DELETE /chaos         clear all faults
+//	POST   /chaos/cpu     burn CPU, e.g. ?duration=10s&cores=2
+//	POST   /chaos/memory  allocate memory, e.g. ?mb=128&duration=30s
+//	POST   /chaos/crash   exit the process, e.g. ?code=1
+
+// chaosLimits bounds what a caller of the fault injection endpoints can do.
+// MaxCPUCores defaults to GOMAXPROCS, which can be set to the CPU limit of
+// the container.
+type chaosLimits struct {
+	MaxLatency     time.Duration `json:"maxLatency"`
+	MaxCPUDuration time.Duration `json:"maxCPUDuration"`
+	MaxCPUCores    int           `json:"maxCPUCores"`
+	MaxMemoryMB    int           `json:"maxMemoryMB"`
+	AllowCrash     bool          `json:"allowCrash"`
+}
+
+// MarshalJSON reports the durations as duration strings.
+func (l chaosLimits) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		MaxLatency     string `json:"maxLatency"`
+		MaxCPUDuration string `json:"maxCPUDuration"`
+		MaxCPUCores    int    `json:"maxCPUCores"`
+		MaxMemoryMB    int    `json:"maxMemoryMB"`
+		AllowCrash     bool   `json:"allowCrash"`
+	}{l.MaxLatency.String(), l.MaxCPUDuration.String(), l.MaxCPUCores, l.MaxMemoryMB, l.AllowCrash})
+}
+
+// faults are the faults applied to every request served by the chaos
+// middleware.
+type faults struct {
+	Latency   time.Duration `json:"latency"`
+	ErrorRate float64       `json:"errorRate"`
+	ErrorCode int           `json:"errorCode"`
+}
+
+// UnmarshalJSON accepts the latency as a duration string such as "500ms".
+func (f *faults) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Latency   string  `json:"latency"`
+		ErrorRate float64 `json:"errorRate"`
+		ErrorCode int     `json:"errorCode"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	f.ErrorRate, f.ErrorCode = v.ErrorRate, v.ErrorCode
+	f.Latency = 0
+	if v.Latency != "" {
+		d, err := time.ParseDuration(v.Latency)
+		if err != nil {
+			return fmt.Errorf("latency: %v", err)
+		}
+		f.Latency = d
+	}
+	return nil
+}
+
+// MarshalJSON reports the latency as a duration string.
+func (f faults) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Latency   string  `json:"latency"`
+		ErrorRate float64 `json:"errorRate"`
+		ErrorCode int     `json:"errorCode"`
+	}{f.Latency.String(), f.ErrorRate, f.ErrorCode})
+}
+
+func (f faults) validate(limits chaosLimits) error {
+	if f.Latency < 0 || f.Latency > limits.MaxLatency {
+		return fmt.Errorf("latency must be between 0 and %s", limits.MaxLatency)
+	}
+	if f.ErrorRate < 0 || f.ErrorRate > 1 {
+		return fmt.Errorf("error rate must be between 0 and 1")
+	}
+	if f.ErrorCode != 0 && (f.ErrorCode < 400 || f.ErrorCode > 599) {
+		return fmt.Errorf("error code must be between 400 and 599")
+	}
+	return nil
+}
+
+// chaos holds the fault injection state of the server.
+type chaos struct {
+	limits chaosLimits
+
+	mtx    sync.Mutex
+	faults faults
+
+	// busyCores is the number of cores currently burnt by /chaos/cpu.
+	busyCores int64
+	// allocatedMB is the memory currently held by /chaos/memory.
+	allocatedMB int64
+}
+
+func newChaos(limits chaosLimits) *chaos {
+	if limits.MaxCPUCores == 0 {
+		limits.MaxCPUCores = runtime.GOMAXPROCS(0)
+	}
+	return &chaos{limits: limits}
+}
+
+func (c *chaos) currentFaults() faults {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.faults
+}
+
+// requestFaults returns the faults for r: the ones set through the admin API,
+// overridden by the latency, error_rate and error_code query parameters.
+func (c *chaos) requestFaults(r *http.Request) (faults, error) {
+	f := c.currentFaults()
+	q := r.URL.Query()
+	var err error
+	if v := q.Get("latency"); v != "" {
+		if f.Latency, err = time.ParseDuration(v); err != nil {
+			return f, fmt.Errorf("latency: %v", err)
+		}
+	}
+	if v := q.Get("error_rate"); v != "" {
+		if f.ErrorRate, err = strconv.ParseFloat(v, 64); err != nil {
+			return f, fmt.Errorf("error_rate: %v", err)
+		}
+	}
+	if v := q.Get("error_code"); v != "" {
+		if f.ErrorCode, err = strconv.Atoi(v); err != nil {
+			return f, fmt.Errorf("error_code: %v", err)
+		}
+	}
+	return f, f.validate(c.limits)
+}
+
+// middleware injects the configured latency and errors before calling h.
+func (c *chaos) middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, err := c.requestFaults(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if f.Latency > 0 {
+			select {
+			case <-time.After(f.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if f.ErrorRate > 0 && rand.Float64() < f.ErrorRate {
+			code := f.ErrorCode
+			if code == 0 {
+				code = http.StatusInternalServerError
+			}
+			log.Printf("Injecting error %d for request: %s", code, r.URL.Path)
+			http.Error(w, "injected error", code)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// register adds the admin API to mux, the mux of the metrics port.
+func (c *chaos) register(mux *http.ServeMux) {
+	mux.Handle("/chaos", instrument("/chaos", http.HandlerFunc(c.faultsHandler)))
+	mux.Handle("/chaos/cpu", instrument("/chaos/cpu", http.HandlerFunc(c.cpuHandler)))
+	mux.Handle("/chaos/memory", instrument("/chaos/memory", http.HandlerFunc(c.memoryHandler)))
+	mux.Handle("/chaos/crash", instrument("/chaos/crash", http.HandlerFunc(c.crashHandler)))
+}
+
+func (c *chaos) faultsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Serving request: %s %s", r.Method, r.URL.Path)
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var f faults
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := f.validate(c.limits); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.mtx.Lock()
+		c.faults = f
+		c.mtx.Unlock()
+		log.Printf("Chaos faults set: latency=%s errorRate=%g errorCode=%d", f.Latency, f.ErrorRate, f.ErrorCode)
+	case http.MethodDelete:
+		c.mtx.Lock()
+		c.faults = faults{}
+		c.mtx.Unlock()
+		log.Printf("Chaos faults cleared")
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Faults      faults      `json:"faults"`
+		Limits      chaosLimits `json:"limits"`
+		BusyCores   int64       `json:"busyCores"`
+		AllocatedMB int64       `json:"allocatedMB"`
+	}{c.currentFaults(), c.limits, atomic.LoadInt64(&c.busyCores), atomic.LoadInt64(&c.allocatedMB)})
+}
+
+// durationParam parses the duration query parameter name, rejecting values
+// that are not positive or exceed max.
+func durationParam(r *http.Request, name string, def, max time.Duration) (time.Duration, error) {
+	d := def
+	if v := r.URL.Query().Get(name); v != "" {
+		var err error
+		if d, err = time.ParseDuration(v); err != nil {
+			return 0, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	if d <= 0 || d > max {
+		return 0, fmt.Errorf("%s must be between 0 and %s", name, max)
+	}
+	return d, nil
+}
+
+// intParam parses the integer query parameter name, rejecting values outside
+// [1, max].
+func intParam(r *http.Request, name string, def, max int) (int, error) {
+	n := def
+	if v := r.URL.Query().Get(name); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil {
+			return 0, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	if n < 1 || n > max {
+		return 0, fmt.Errorf("%s must be between 1 and %d", name, max)
+	}
+	return n, nil
+}
+
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// cpuHandler keeps the requested number of cores busy for the requested
+// duration. It returns immediately. The total number of cores burnt by
+// concurrent calls is capped.
+func (c *chaos) cpuHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Serving request: %s %s", r.Method, r.URL.Path)
+	if !requirePost(w, r) {
+		return
+	}
+	d, err := durationParam(r, "duration", 10*time.Second, c.limits.MaxCPUDuration)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cores, err := intParam(r, "cores", 1, c.limits.MaxCPUCores)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if total := atomic.AddInt64(&c.busyCores, int64(cores)); total > int64(c.limits.MaxCPUCores) {
+		atomic.AddInt64(&c.busyCores, -int64(cores))
+		http.Error(w, fmt.Sprintf("burning %d more cores would exceed the limit of %d cores", cores, c.limits.MaxCPUCores), http.StatusTooManyRequests)
+		return
+	}
+
+	log.Printf("Burning %d cores for %s", cores, d)
+	deadline := time.Now().Add(d)
+	for i := 0; i < cores; i++ {
+		go func() {
+			for time.Now().Before(deadline) {
+			}
+			atomic.AddInt64(&c.busyCores, -1)
+		}()
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Burning %d cores for %s\n", cores, d)
+}
+
+// memoryHandler allocates the requested amount of memory and holds it for the
+// requested duration. The total held by concurrent calls is capped.
+func (c *chaos) memoryHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Serving request: %s %s", r.Method, r.URL.Path)
+	if !requirePost(w, r) {
+		return
+	}
+	mb, err := intParam(r, "mb", 64, c.limits.MaxMemoryMB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d, err := durationParam(r, "duration", 30*time.Second, time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if total := atomic.AddInt64(&c.allocatedMB, int64(mb)); total > int64(c.limits.MaxMemoryMB) {
+		atomic.AddInt64(&c.allocatedMB, -int64(mb))
+		http.Error(w, fmt.Sprintf("allocating %d MB would exceed the limit of %d MB", mb, c.limits.MaxMemoryMB), http.StatusTooManyRequests)
+		return
+	}
+
+	log.Printf("Allocating %d MB for %s", mb, d)
+	buf := make([]byte, mb<<20)
+	// Touch every page so that the memory is actually resident.
+	for i := 0; i < len(buf); i += os.Getpagesize() {
+		buf[i] = 1
+	}
+	go func() {
+		time.Sleep(d)
+		runtime.KeepAlive(buf)
+		atomic.AddInt64(&c.allocatedMB, -int64(mb))
+		log.Printf("Released %d MB", mb)
+	}()
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Allocated %d MB for %s\n", mb, d)
+}
+
+// crashHandler terminates the process with the requested exit code after the
+// response has been sent.
+func (c *chaos) crashHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Serving request: %s %s", r.Method, r.URL.Path)
+	if !requirePost(w, r) {
+		return
+	}
+	if !c.limits.AllowCrash {
+		http.Error(w, "crashing is disabled, set chaosAllowCrash to enable it", http.StatusForbidden)
+		return
+	}
+	code, err := intParam(r, "code", 1, 255)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Exiting with code %d\n", code)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		log.Printf("Exiting with code %d on request", code)
+		os.Exit(code)
+	}()
+}
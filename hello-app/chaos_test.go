@@ -0,0 +1,152 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testChaosLimits = chaosLimits{
+	MaxLatency:     time.Second,
+	MaxCPUDuration: time.Second,
+	MaxCPUCores:    2,
+	MaxMemoryMB:    2,
+}
+
+func TestChaosRequestFaults(t *testing.T) {
+	c := newChaos(testChaosLimits)
+	c.faults = faults{Latency: 100 * time.Millisecond, ErrorRate: 0.5}
+	for _, tc := range []struct {
+		query string
+		want  faults
+		err   string
+	}{
+		{"", faults{Latency: 100 * time.Millisecond, ErrorRate: 0.5}, ""},
+		{"latency=200ms", faults{Latency: 200 * time.Millisecond, ErrorRate: 0.5}, ""},
+		{"error_rate=1&error_code=503", faults{Latency: 100 * time.Millisecond, ErrorRate: 1, ErrorCode: 503}, ""},
+		{"latency=2s", faults{}, "latency must be between 0 and 1s"},
+		{"latency=-1s", faults{}, "latency must be between 0 and 1s"},
+		{"latency=soon", faults{}, "latency:"},
+		{"error_rate=1.5", faults{}, "error rate must be between 0 and 1"},
+		{"error_rate=x", faults{}, "error_rate:"},
+		{"error_code=302", faults{}, "error code must be between 400 and 599"},
+		{"error_code=600", faults{}, "error code must be between 400 and 599"},
+	} {
+		got, err := c.requestFaults(httptest.NewRequest("GET", "/?"+tc.query, nil))
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("requestFaults(%q) error = %v", tc.query, err)
+		case tc.err == "" && got != tc.want:
+			t.Errorf("requestFaults(%q) = %+v, want %+v", tc.query, got, tc.want)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("requestFaults(%q) error = %v, want it to contain %q", tc.query, err, tc.err)
+		}
+	}
+}
+
+func TestChaosMiddleware(t *testing.T) {
+	c := newChaos(testChaosLimits)
+	h := c.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for query, want := range map[string]int{
+		"":                            200,
+		"error_rate=1":                500,
+		"error_rate=1&error_code=503": 503,
+		"error_rate=0&error_code=503": 200,
+		"latency=10s":                 400,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/?"+query, nil))
+		if w.Code != want {
+			t.Errorf("GET /?%s: status %d, want %d", query, w.Code, want)
+		}
+	}
+}
+
+func TestChaosFaultsHandler(t *testing.T) {
+	c := newChaos(testChaosLimits)
+	for _, tc := range []struct {
+		method, body string
+		want         int
+	}{
+		{"PUT", `{"latency":"500ms","errorRate":0.1}`, 200},
+		{"PUT", `{"latency":"5s"}`, 400},
+		{"PUT", `{"errorCode":200}`, 400},
+		{"PUT", `{"latency":"fast"}`, 400},
+		{"PATCH", `{}`, 405},
+		{"DELETE", "", 200},
+	} {
+		w := httptest.NewRecorder()
+		c.faultsHandler(w, httptest.NewRequest(tc.method, "/chaos", strings.NewReader(tc.body)))
+		if w.Code != tc.want {
+			t.Errorf("%s /chaos %s: status %d, want %d", tc.method, tc.body, w.Code, tc.want)
+		}
+	}
+	if f := c.currentFaults(); f != (faults{}) {
+		t.Errorf("faults after DELETE = %+v, want none", f)
+	}
+}
+
+func TestChaosResourceLimits(t *testing.T) {
+	c := newChaos(testChaosLimits)
+	for _, tc := range []struct {
+		handler http.HandlerFunc
+		query   string
+		want    int
+	}{
+		{c.cpuHandler, "cores=3&duration=100ms", 400},
+		{c.cpuHandler, "cores=1&duration=2s", 400},
+		{c.cpuHandler, "cores=2&duration=100ms", 202},
+		{c.cpuHandler, "cores=1&duration=100ms", 429}, // all cores are busy
+		{c.memoryHandler, "mb=3&duration=100ms", 400},
+		{c.memoryHandler, "mb=2&duration=100ms", 202},
+		{c.memoryHandler, "mb=1&duration=100ms", 429}, // all memory is held
+		{c.crashHandler, "code=1", 403},               // crashes are not allowed
+	} {
+		w := httptest.NewRecorder()
+		tc.handler(w, httptest.NewRequest("POST", "/?"+tc.query, nil))
+		if w.Code != tc.want {
+			t.Errorf("POST ?%s: status %d, want %d: %s", tc.query, w.Code, tc.want, w.Body)
+		}
+	}
+
+	// The resources are released once the duration has passed.
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		w := httptest.NewRecorder()
+		c.cpuHandler(w, httptest.NewRequest("POST", "/?cores=2&duration=10ms", nil))
+		if w.Code == 202 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Error("cores were not released")
+}
+
+func TestConfigValidateChaosLimits(t *testing.T) {
+	cfg := config{Port: "8080", MetricsPort: "9090"}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("validate() with chaos disabled = %v, want no error", err)
+	}
+	cfg.Chaos = true
+	if err := cfg.validate(); err == nil {
+		t.Error("validate() with chaos enabled and no limits = nil, want an error")
+	}
+}
@@ -0,0 +1,171 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Name    string        `yaml:"name" env:"TEST_NAME"`
+	Port    string        `yaml:"port" env:"TEST_PORT"`
+	Timeout time.Duration `yaml:"timeout" env:"TEST_TIMEOUT"`
+	Hosts   []string      `yaml:"hosts" env:"TEST_HOSTS"`
+	Debug   bool          `yaml:"debug"`
+	Token   string        `yaml:"token" env:"TEST_TOKEN" secret:"true"`
+	Key     string        `yaml:"key" secret:"true"`
+	CAFile  string        `yaml:"tlsCAFile" flag:"tls-ca-file"`
+}
+
+func (c *testConfig) validate() error {
+	if c.Port == "invalid" {
+		return errors.New("bad port")
+	}
+	return nil
+}
+
+func parseTestConfig(t *testing.T, cfg *testConfig, args ...string) (string, bool, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var out bytes.Buffer
+	printed, err := parseConfig(fs, args, &out, cfg)
+	return out.String(), printed, err
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFlagName(t *testing.T) {
+	for key, want := range map[string]string{
+		"port":                      "port",
+		"metricsPort":               "metrics-port",
+		"maxCPUDuration":            "max-cpu-duration",
+		"tlsClientCA":               "tls-client-ca",
+		"tlsSelfSignedSANs":         "tls-self-signed-sans",
+		"proxyProtocolTrustedCIDRs": "proxy-protocol-trusted-cidrs",
+		"redisTLS":                  "redis-tls",
+		"redisTLSServerName":        "redis-tls-server-name",
+		"hstsIncludeSubdomains":     "hsts-include-subdomains",
+	} {
+		if got := flagName(key); got != want {
+			t.Errorf("flagName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestParseConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "name: file\nport: \"1000\"\ntimeout: 1s\nhosts: [a, b]\n")
+	t.Setenv("TEST_PORT", "2000")
+	t.Setenv("TEST_TIMEOUT", "2s")
+	t.Setenv("TEST_HOSTS", "")
+
+	cfg := testConfig{Name: "default", Port: "80"}
+	if _, _, err := parseTestConfig(t, &cfg, "-config", path, "-timeout", "3s", "-debug", "-tls-ca-file", "ca.crt"); err != nil {
+		t.Fatal(err)
+	}
+	want := testConfig{
+		Name:    "file",             // file over default
+		Port:    "2000",             // environment over file
+		Timeout: 3 * time.Second,    // flag over environment
+		Hosts:   []string{"a", "b"}, // empty environment variables are ignored
+		Debug:   true,
+		CAFile:  "ca.crt", // flag named by the flag tag
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("config = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestParseConfigFileFromEnvironment(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "name: file\n"))
+	var cfg testConfig
+	if _, _, err := parseTestConfig(t, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "file" {
+		t.Errorf("name = %q, want %q", cfg.Name, "file")
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		file string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		"unknown file key": {file: "nmae: x\n", want: "field nmae not found"},
+		"bad environment":  {env: map[string]string{"TEST_TIMEOUT": "soon"}, want: "environment variable TEST_TIMEOUT"},
+		"bad flag":         {args: []string{"-debug=maybe"}, want: "flag -debug"},
+		"validation":       {args: []string{"-port", "invalid"}, want: "invalid configuration: bad port"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			args := tc.args
+			if tc.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, tc.file)}, args...)
+			}
+			var cfg testConfig
+			_, _, err := parseTestConfig(t, &cfg, args...)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigPrintRedactsSecrets(t *testing.T) {
+	t.Setenv("TEST_TOKEN", "s3cret")
+	cfg := testConfig{Name: "app"}
+	out, printed, err := parseTestConfig(t, &cfg, "-print-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !printed {
+		t.Fatal("-print-config did not print the configuration")
+	}
+	if strings.Contains(out, "s3cret") {
+		t.Errorf("printed configuration contains the secret:\n%s", out)
+	}
+	for _, line := range []string{"name: app", "token: REDACTED", `key: ""`} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("printed configuration does not contain %q:\n%s", line, out)
+		}
+	}
+	// Redaction only applies to the printed copy.
+	if cfg.Token != "s3cret" {
+		t.Errorf("token = %q, want %q", cfg.Token, "s3cret")
+	}
+}
@@ -0,0 +1,189 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Debugging endpoints that show what reached the pod after ingress, IAP or
+// Gateway processing. They are registered only when debugEndpoints is true.
+
+// maxEchoBody is the largest request body that /echo reflects back.
+const maxEchoBody = 1 << 20
+
+// echoResponse describes the request received by /echo.
+type echoResponse struct {
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	Proto      string              `json:"proto"`
+	Host       string              `json:"host"`
+	RemoteAddr string              `json:"remoteAddr"`
+	ClientIPs  []string            `json:"clientIPs"`
+	Headers    map[string][]string `json:"headers"`
+	Body       string              `json:"body,omitempty"`
+	BodyBase64 bool                `json:"bodyBase64,omitempty"`
+	Truncated  bool                `json:"bodyTruncated,omitempty"`
+	TLS        *echoTLS            `json:"tls,omitempty"`
+}
+
+// echoTLS describes the TLS connection a request was received on.
+type echoTLS struct {
+	Version            string   `json:"version"`
+	CipherSuite        string   `json:"cipherSuite"`
+	ServerName         string   `json:"serverName,omitempty"`
+	NegotiatedProtocol string   `json:"negotiatedProtocol,omitempty"`
+	PeerCertificates   []string `json:"peerCertificates,omitempty"`
+}
+
+// echo responds with the method, headers, body, TLS details and client IP
+// chain of the request as JSON.
+func echo(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Serving request: %s", r.URL.Path)
+	resp := echoResponse{
+		Method:     r.Method,
+		URL:        r.URL.String(),
+		Proto:      r.Proto,
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+		ClientIPs:  clientIPChain(r),
+		Headers:    r.Header,
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxEchoBody+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxEchoBody {
+		body, resp.Truncated = body[:maxEchoBody], true
+	}
+	if utf8.Valid(body) {
+		resp.Body = string(body)
+	} else {
+		resp.Body, resp.BodyBase64 = base64.StdEncoding.EncodeToString(body), true
+	}
+
+	if cs := r.TLS; cs != nil {
+		resp.TLS = &echoTLS{
+			Version:            tls.VersionName(cs.Version),
+			CipherSuite:        tls.CipherSuiteName(cs.CipherSuite),
+			ServerName:         cs.ServerName,
+			NegotiatedProtocol: cs.NegotiatedProtocol,
+		}
+		for _, cert := range cs.PeerCertificates {
+			resp.TLS.PeerCertificates = append(resp.TLS.PeerCertificates, cert.Subject.String())
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(resp); err != nil {
+		log.Printf("Failed to write echo response: %v", err)
+	}
+}
+
+// clientIPChain returns the addresses the request went through, starting with
+// the original client: the "for" parameters of the Forwarded header, or the
+// X-Forwarded-For entries if there is no Forwarded header, followed by the
+// address of the peer that connected to the server.
+func clientIPChain(r *http.Request) []string {
+	var chain []string
+	if fwd := r.Header.Values("Forwarded"); len(fwd) > 0 {
+		for _, elem := range strings.Split(strings.Join(fwd, ","), ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				k, v, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(k, "for") {
+					chain = append(chain, unquoteNode(v))
+				}
+			}
+		}
+	} else {
+		for _, xff := range r.Header.Values("X-Forwarded-For") {
+			for _, ip := range strings.Split(xff, ",") {
+				if ip = strings.TrimSpace(ip); ip != "" {
+					chain = append(chain, ip)
+				}
+			}
+		}
+	}
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	return append(chain, peer)
+}
+
+// unquoteNode strips the quotes, brackets and port from a Forwarded node such
+// as `"[2001:db8::1]:4711"`.
+func unquoteNode(v string) string {
+	v = strings.Trim(v, `"`)
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		return host
+	}
+	return strings.Trim(v, "[]")
+}
+
+// secretMarkers are substrings of environment variable names whose values are
+// redacted by /env.
+var secretMarkers = []string{"PASSWORD", "PASSWD", "SECRET", "TOKEN", "KEY", "CREDENTIAL", "AUTH", "PRIVATE"}
+
+// env responds with the environment of the process as JSON, sorted by name.
+// Values of variables that look like secrets are redacted, and the prefix
+// query parameter restricts the output to variables whose name starts with it.
+func env(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Serving request: %s", r.URL.Path)
+	prefix := r.URL.Query().Get("prefix")
+	vars := make(map[string]string)
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if isSecret(k) {
+			v = "REDACTED"
+		}
+		vars[k] = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(vars); err != nil {
+		log.Printf("Failed to write env response: %v", err)
+	}
+}
+
+func isSecret(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, m := range secretMarkers {
+		if strings.Contains(upper, m) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestClientIPChain(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		headers    map[string][]string
+		remoteAddr string
+		want       []string
+	}{
+		{"no headers", nil, "10.0.0.1:1234", []string{"10.0.0.1"}},
+		{"peer without port", nil, "10.0.0.1", []string{"10.0.0.1"}},
+		{"IPv6 peer", nil, "[2001:db8::2]:1234", []string{"2001:db8::2"}},
+		{
+			"X-Forwarded-For",
+			map[string][]string{"X-Forwarded-For": {"203.0.113.1, 198.51.100.1"}},
+			"10.0.0.1:1234",
+			[]string{"203.0.113.1", "198.51.100.1", "10.0.0.1"},
+		},
+		{
+			"repeated X-Forwarded-For with empty entries",
+			map[string][]string{"X-Forwarded-For": {"203.0.113.1,, ", "198.51.100.1"}},
+			"10.0.0.1:1234",
+			[]string{"203.0.113.1", "198.51.100.1", "10.0.0.1"},
+		},
+		{
+			"Forwarded",
+			map[string][]string{"Forwarded": {"for=203.0.113.1;proto=https, For=198.51.100.1"}},
+			"10.0.0.1:1234",
+			[]string{"203.0.113.1", "198.51.100.1", "10.0.0.1"},
+		},
+		{
+			"quoted Forwarded values",
+			map[string][]string{"Forwarded": {`for="203.0.113.1:4711";by=proxy`, `proto=http;for="_hidden"`}},
+			"10.0.0.1:1234",
+			[]string{"203.0.113.1", "_hidden", "10.0.0.1"},
+		},
+		{
+			"IPv6 in Forwarded",
+			map[string][]string{"Forwarded": {`for="[2001:db8::1]:4711", for="[2001:db8::3]"`}},
+			"10.0.0.1:1234",
+			[]string{"2001:db8::1", "2001:db8::3", "10.0.0.1"},
+		},
+		{
+			"Forwarded takes precedence over X-Forwarded-For",
+			map[string][]string{
+				"Forwarded":       {"for=203.0.113.1"},
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			"10.0.0.1:1234",
+			[]string{"203.0.113.1", "10.0.0.1"},
+		},
+	} {
+		r := httptest.NewRequest("GET", "/echo", nil)
+		r.RemoteAddr = tc.remoteAddr
+		for k, vs := range tc.headers {
+			for _, v := range vs {
+				r.Header.Add(k, v)
+			}
+		}
+		if got := clientIPChain(r); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: clientIPChain() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestUnquoteNode(t *testing.T) {
+	for v, want := range map[string]string{
+		"203.0.113.1":          "203.0.113.1",
+		`"203.0.113.1:4711"`:   "203.0.113.1",
+		`"[2001:db8::1]:4711"`: "2001:db8::1",
+		`"[2001:db8::1]"`:      "2001:db8::1",
+		"unknown":              "unknown",
+		`"_obfuscated:_port"`:  "_obfuscated",
+	} {
+		if got := unquoteNode(v); got != want {
+			t.Errorf("unquoteNode(%q) = %q, want %q", v, got, want)
+		}
+	}
+}
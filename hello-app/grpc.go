@@ -0,0 +1,92 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+
+	pb "hello-app/protos"
+)
+
+// helloServer implements the hello.v1.Hello gRPC service.
+type helloServer struct {
+	pb.UnimplementedHelloServer
+}
+
+// SayHello responds with the same information as the HTTP hello handler.
+func (helloServer) SayHello(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+	log.Printf("Serving gRPC request: SayHello")
+	name := req.GetName()
+	if name == "" {
+		name = "world"
+	}
+	host, _ := os.Hostname()
+	return &pb.HelloReply{
+		Message:      "Hello, " + name + "!",
+		Version:      build.Version,
+		Hostname:     host,
+		PodName:      os.Getenv("POD_NAME"),
+		PodNamespace: os.Getenv("POD_NAMESPACE"),
+		PodIp:        os.Getenv("POD_IP"),
+		NodeName:     os.Getenv("NODE_NAME"),
+		Zone:         os.Getenv("ZONE"),
+	}, nil
+}
+
+// newGRPCServer returns a gRPC server with the Hello service, the standard
+// health checking service and server reflection registered.
+func newGRPCServer() *grpc.Server {
+	s := grpc.NewServer()
+	pb.RegisterHelloServer(s, helloServer{})
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus(pb.Hello_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, healthServer)
+
+	reflection.Register(s)
+	return s
+}
+
+// grpcHandler sends gRPC requests, recognized by their HTTP/2 protocol and
+// application/grpc content type, to s and all other requests to h.
+func grpcHandler(s *grpc.Server, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			s.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// h2cHandler lets h serve HTTP/2 over cleartext connections, either with prior
+// knowledge or through an HTTP/1.1 Upgrade, in addition to HTTP/1.1.
+func h2cHandler(h http.Handler) http.Handler {
+	return h2c.NewHandler(h, &http2.Server{})
+}
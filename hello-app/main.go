@@ -19,35 +19,125 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
-	"os"
+	"time"
 )
 
+// config holds the settings of the server. They are loaded from a YAML file,
+// environment variables and flags by loadConfig.
+type config struct {
+	Port           string `yaml:"port" env:"PORT" usage:"port to serve HTTP on"`
+	MetricsPort    string `yaml:"metricsPort" env:"METRICS_PORT" usage:"port to serve Prometheus metrics on"`
+	Version        string `yaml:"version" env:"VERSION" usage:"override the version reported by the server"`
+	H2C            bool   `yaml:"h2c" env:"H2C_ENABLED" usage:"serve HTTP/2 over cleartext"`
+	GRPC           bool   `yaml:"grpc" env:"GRPC_ENABLED" usage:"serve the gRPC Hello service on the same port, implies -h2c"`
+	DebugEndpoints bool   `yaml:"debugEndpoints" env:"DEBUG_ENDPOINTS" usage:"serve the /echo and /env endpoints"`
+
+	Chaos               bool          `yaml:"chaos" env:"CHAOS_ENABLED" usage:"enable fault injection"`
+	ChaosMaxLatency     time.Duration `yaml:"chaosMaxLatency" env:"CHAOS_MAX_LATENCY" usage:"longest latency that can be injected"`
+	ChaosMaxCPUDuration time.Duration `yaml:"chaosMaxCPUDuration" env:"CHAOS_MAX_CPU_DURATION" usage:"longest CPU burn"`
+	ChaosMaxMemoryMB    int           `yaml:"chaosMaxMemoryMB" env:"CHAOS_MAX_MEMORY_MB" usage:"total memory that can be allocated at once, in MB"`
+	ChaosAllowCrash     bool          `yaml:"chaosAllowCrash" env:"CHAOS_ALLOW_CRASH" usage:"allow clients to crash the process"`
+}
+
+func (c *config) validate() error {
+	if err := validatePort("port", c.Port); err != nil {
+		return err
+	}
+	if err := validatePort("metricsPort", c.MetricsPort); err != nil {
+		return err
+	}
+	if c.Port == c.MetricsPort {
+		return errors.New("port and metricsPort must be different")
+	}
+	if c.Chaos && (c.ChaosMaxLatency <= 0 || c.ChaosMaxCPUDuration <= 0 || c.ChaosMaxMemoryMB <= 0) {
+		return errors.New("chaos limits must be positive")
+	}
+	return nil
+}
+
 func main() {
-	// register hello function to handle all requests
+	cfg := config{
+		Port:                "8080",
+		MetricsPort:         "9090",
+		ChaosMaxLatency:     10 * time.Second,
+		ChaosMaxCPUDuration: 30 * time.Second,
+		ChaosMaxMemoryMB:    256,
+	}
+	if err := loadConfig(&cfg); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Version != "" {
+		build.Version = cfg.Version
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", hello)
+	mux.Handle("/version", instrument("/version", http.HandlerFunc(versionHandler)))
+
+	// expose /echo and /env for debugging
+	if cfg.DebugEndpoints {
+		mux.Handle("/echo", instrument("/echo", http.HandlerFunc(echo)))
+		mux.Handle("/env", instrument("/env", http.HandlerFunc(env)))
+		log.Printf("Debug endpoints enabled")
+	}
 
-	// use PORT environment variable, or default to 8080
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// inject faults into hello, controlled from the metrics port
+	handler := http.Handler(http.HandlerFunc(hello))
+	var c *chaos
+	if cfg.Chaos {
+		c = newChaos(chaosLimits{
+			MaxLatency:     cfg.ChaosMaxLatency,
+			MaxCPUDuration: cfg.ChaosMaxCPUDuration,
+			MaxMemoryMB:    cfg.ChaosMaxMemoryMB,
+			AllowCrash:     cfg.ChaosAllowCrash,
+		})
+		handler = c.middleware(handler)
+		log.Printf("Chaos endpoints enabled on port %s", cfg.MetricsPort)
 	}
+	// register hello function to handle all requests
+	mux.Handle("/", instrument("/", handler))
+
+	// serve HTTP/2 over cleartext, and the gRPC Hello service on the same port
+	server := http.Handler(mux)
+	if cfg.GRPC {
+		server = grpcHandler(newGRPCServer(), server)
+		log.Printf("gRPC enabled")
+	}
+	if cfg.H2C || cfg.GRPC {
+		server = h2cHandler(server)
+		log.Printf("HTTP/2 cleartext enabled")
+	}
+
+	go serveMetrics(cfg.MetricsPort, c)
 
 	// start the web server on port and accept requests
-	log.Printf("Server listening on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, mux))
+	log.Printf("hello-app version %s", build.Version)
+	log.Printf("Server listening on port %s", cfg.Port)
+	log.Fatal(http.ListenAndServe(":"+cfg.Port, server))
 }
 
-// hello responds to the request with a plain-text "Hello, world" message.
+// hello responds to the request with a "Hello, world" message. The response is
+// plain text unless the Accept header asks for JSON or HTML, and 406 Not
+// Acceptable if it accepts none of them.
 func hello(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Serving request: %s", r.URL.Path)
-	host, _ := os.Hostname()
-	fmt.Fprintf(w, "Hello, world!\n")
-	fmt.Fprintf(w, "Version: 1.0.0\n")
-	fmt.Fprintf(w, "Hostname: %s\n", host)
+	w.Header().Set("Vary", "Accept")
+	format, ok := negotiate(r.Header.Get("Accept"))
+	if !ok {
+		http.Error(w, "406 - Not Acceptable: the response is available as text/plain, application/json or text/html", http.StatusNotAcceptable)
+		return
+	}
+	info := newHelloInfo(r)
+	switch format {
+	case formatJSON:
+		writeJSON(w, info)
+	case formatHTML:
+		writeHTML(w, info)
+	default:
+		writeText(w, info)
+	}
 }
 
 // [END container_hello_app]
@@ -0,0 +1,83 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Request rate, errors and duration (RED) metrics. Errors are the requests
+// with a 5xx code, e.g.
+//
+//	sum by (route) (rate(hello_app_http_requests_total{code=~"5.."}[1m]))
+var (
+	reg          = prometheus.NewRegistry()
+	requestCount = promauto.With(reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hello_app_http_requests_total",
+			Help: "Total number of HTTP requests by route, status code and method.",
+		},
+		[]string{"route", "code", "method"},
+	)
+	requestDuration = promauto.With(reg).NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "hello_app_http_request_duration_seconds",
+			Help:    "Duration of HTTP requests by route, status code and method.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"route", "code", "method"},
+	)
+	requestsInFlight = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "hello_app_http_requests_in_flight",
+		Help: "Number of HTTP requests currently being served.",
+	})
+)
+
+func init() {
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// instrument records the RED metrics of h under the given route label. The
+// route is the pattern the handler is registered with rather than the request
+// path, which keeps the label cardinality bounded.
+func instrument(route string, h http.Handler) http.Handler {
+	labels := prometheus.Labels{"route": route}
+	return promhttp.InstrumentHandlerInFlight(requestsInFlight,
+		promhttp.InstrumentHandlerDuration(requestDuration.MustCurryWith(labels),
+			promhttp.InstrumentHandlerCounter(requestCount.MustCurryWith(labels), h)))
+}
+
+// serveMetrics exposes the metrics on their own port so that they are not
+// reachable through the load balancer that fronts the application.
+func serveMetrics(port string, c *chaos) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	if c != nil {
+		c.register(mux)
+	}
+	log.Printf("Metrics and admin endpoints listening on port %s", port)
+	log.Fatal(http.ListenAndServe(":"+port, mux))
+}
@@ -0,0 +1,215 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// responseFormat is a representation of the hello response that a client can
+// ask for through the Accept header.
+type responseFormat int
+
+const (
+	formatText responseFormat = iota
+	formatJSON
+	formatHTML
+)
+
+// offers lists the media types the server can produce, in order of
+// preference. The first entry is also used when the client does not send an
+// Accept header.
+var offers = []struct {
+	mediaType string
+	format    responseFormat
+}{
+	{"text/plain", formatText},
+	{"application/json", formatJSON},
+	{"text/html", formatHTML},
+}
+
+// helloInfo is the data reported by the hello handler. Pod, namespace, pod IP,
+// node and zone are read from environment variables, populated through the
+// Kubernetes Downward API except for the zone, and are omitted when unset.
+type helloInfo struct {
+	Message   string              `json:"message"`
+	Version   string              `json:"version"`
+	Hostname  string              `json:"hostname"`
+	Pod       string              `json:"pod,omitempty"`
+	Namespace string              `json:"namespace,omitempty"`
+	PodIP     string              `json:"podIP,omitempty"`
+	Node      string              `json:"node,omitempty"`
+	Zone      string              `json:"zone,omitempty"`
+	Headers   map[string][]string `json:"headers"`
+}
+
+func newHelloInfo(r *http.Request) helloInfo {
+	host, _ := os.Hostname()
+	return helloInfo{
+		Message:   "Hello, world!",
+		Version:   build.Version,
+		Hostname:  host,
+		Pod:       os.Getenv("POD_NAME"),
+		Namespace: os.Getenv("POD_NAMESPACE"),
+		PodIP:     os.Getenv("POD_IP"),
+		Node:      os.Getenv("NODE_NAME"),
+		Zone:      os.Getenv("ZONE"),
+		Headers:   r.Header,
+	}
+}
+
+// negotiate picks the response format for the given Accept header value. Each
+// offer is weighted with the quality of the most specific media range that
+// matches it; ties are broken by the server's order of preference. It returns
+// false if the client accepts none of the offers.
+func negotiate(accept string) (responseFormat, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0].format, true
+	}
+	ranges := parseAccept(accept)
+
+	best, bestQ := offers[0].format, 0.0
+	for _, o := range offers {
+		if q := quality(ranges, o.mediaType); q > bestQ {
+			best, bestQ = o.format, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// mediaRange is a single element of an Accept header, e.g. "text/*;q=0.5".
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		typ, subtype, ok := strings.Cut(strings.TrimSpace(params[0]), "/")
+		if !ok {
+			continue
+		}
+		mr := mediaRange{typ: strings.ToLower(typ), subtype: strings.ToLower(subtype), q: 1}
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if q, err := strconv.ParseFloat(v, 64); err == nil && q >= 0 && q <= 1 {
+					mr.q = q
+				}
+			}
+		}
+		ranges = append(ranges, mr)
+	}
+	return ranges
+}
+
+// quality returns the weight the client assigned to mediaType, or 0 if no
+// range matches it.
+func quality(ranges []mediaRange, mediaType string) float64 {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, mr := range ranges {
+		s := -1
+		switch {
+		case mr.typ == typ && mr.subtype == subtype:
+			s = 2
+		case mr.typ == typ && mr.subtype == "*":
+			s = 1
+		case mr.typ == "*" && mr.subtype == "*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = mr.q, s
+		}
+	}
+	return q
+}
+
+func writeText(w http.ResponseWriter, info helloInfo) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%s\n", info.Message)
+	fmt.Fprintf(w, "Version: %s\n", info.Version)
+	fmt.Fprintf(w, "Hostname: %s\n", info.Hostname)
+}
+
+func writeJSON(w http.ResponseWriter, info helloInfo) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(info); err != nil {
+		log.Printf("Failed to write JSON response: %v", err)
+	}
+}
+
+var helloPage = template.Must(template.New("hello").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Message}}</title>
+</head>
+<body>
+  <h1>{{.Message}}</h1>
+  <table>
+    <tr><th align="left">Version</th><td>{{.Version}}</td></tr>
+    <tr><th align="left">Hostname</th><td>{{.Hostname}}</td></tr>
+    {{- with .Pod}}
+    <tr><th align="left">Pod</th><td>{{.}}</td></tr>{{end}}
+    {{- with .Namespace}}
+    <tr><th align="left">Namespace</th><td>{{.}}</td></tr>{{end}}
+    {{- with .PodIP}}
+    <tr><th align="left">Pod IP</th><td>{{.}}</td></tr>{{end}}
+    {{- with .Node}}
+    <tr><th align="left">Node</th><td>{{.}}</td></tr>{{end}}
+    {{- with .Zone}}
+    <tr><th align="left">Zone</th><td>{{.}}</td></tr>{{end}}
+  </table>
+  <h2>Request headers</h2>
+  <table>
+    {{- range .HeaderNames}}
+    <tr><th align="left">{{.}}</th><td>{{range index $.Headers .}}{{.}}<br>{{end}}</td></tr>{{end}}
+  </table>
+</body>
+</html>
+`))
+
+// HeaderNames returns the request header names in sorted order so the HTML
+// page is stable between requests.
+func (i helloInfo) HeaderNames() []string {
+	names := make([]string, 0, len(i.Headers))
+	for name := range i.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeHTML(w http.ResponseWriter, info helloInfo) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := helloPage.Execute(w, info); err != nil {
+		log.Printf("Failed to write HTML response: %v", err)
+	}
+}
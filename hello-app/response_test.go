@@ -0,0 +1,90 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseAccept(t *testing.T) {
+	for accept, want := range map[string][]mediaRange{
+		"text/html":                  {{"text", "html", 1}},
+		"Text/HTML;Q=0.5":            {{"text", "html", 0.5}},
+		"text/*;q=0.2, */*;q=0.1":    {{"text", "*", 0.2}, {"*", "*", 0.1}},
+		"text/html;level=1;q=0":      {{"text", "html", 0}},
+		"application/json;q=2":       {{"application", "json", 1}}, // out of range q is ignored
+		"application/json;q=x":       {{"application", "json", 1}},
+		"garbage, application/json ": {{"application", "json", 1}},
+		"":                           nil,
+	} {
+		if got := parseAccept(accept); !reflect.DeepEqual(got, want) {
+			t.Errorf("parseAccept(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, tc := range []struct {
+		accept string
+		want   responseFormat
+		ok     bool
+	}{
+		{"", formatText, true},
+		{"*/*", formatText, true},
+		{"application/json", formatJSON, true},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", formatHTML, true},
+		{"text/*", formatText, true},
+		{"text/*;q=0.5, application/json;q=0.9", formatJSON, true},
+		{"application/json;q=0.5, text/html;q=0.5", formatJSON, true}, // ties follow the server's preference
+		{"text/plain;q=0, */*", formatJSON, true},                     // most specific range wins
+		{"*/*;q=0.1, text/html", formatHTML, true},
+		{"text/plain;q=0", 0, false},
+		{"image/png", 0, false},
+		{"application/*;q=0, text/*;q=0", 0, false},
+	} {
+		got, ok := negotiate(tc.accept)
+		if ok != tc.ok || ok && got != tc.want {
+			t.Errorf("negotiate(%q) = %v, %v, want %v, %v", tc.accept, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestHelloContentType(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                 "text/plain; charset=utf-8",
+		"application/json": "application/json",
+		"text/html":        "text/html; charset=utf-8",
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		hello(w, r)
+		if w.Code != 200 || w.Header().Get("Content-Type") != want {
+			t.Errorf("Accept %q: status %d, Content-Type %q, want 200, %q", accept, w.Code, w.Header().Get("Content-Type"), want)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "image/png")
+	w := httptest.NewRecorder()
+	hello(w, r)
+	if w.Code != 406 {
+		t.Errorf("Accept image/png: status %d, want 406", w.Code)
+	}
+}
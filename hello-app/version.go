@@ -0,0 +1,86 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// These values are injected at build time, for example:
+//
+//	go build -ldflags "-X main.version=2.0.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// commit falls back to the VCS information stamped by the Go toolchain when
+// it is not set.
+var (
+	version   = "1.0.0"
+	commit    = ""
+	buildTime = ""
+)
+
+// buildInfo describes the running binary. It is reported by the /version
+// endpoint.
+type buildInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit,omitempty"`
+	Modified   bool   `json:"modified,omitempty"`
+	CommitTime string `json:"commitTime,omitempty"`
+	BuildTime  string `json:"buildTime,omitempty"`
+	GoVersion  string `json:"goVersion"`
+}
+
+// build is resolved once at startup. The version setting overrides the
+// compiled-in version so that a single image can play the part of several
+// releases, e.g. in traffic-splitting demos.
+var build = readBuildInfo()
+
+func readBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				info.CommitTime = s.Value
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+// versionHandler responds with the build information of the server as JSON.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Serving request: %s", r.URL.Path)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(build); err != nil {
+		log.Printf("Failed to write version response: %v", err)
+	}
+}
@@ -4,26 +4,36 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 )
 
-func main() {
-	http.HandleFunc("/", handler)
+// config holds the settings of the service. They are loaded from a YAML file,
+// environment variables and flags by loadConfig.
+type config struct {
+	Port   string `yaml:"port" env:"PORT" usage:"port to listen on"`
+	Target string `yaml:"target" env:"TARGET" usage:"name to greet"`
+}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+func (c *config) validate() error {
+	return validatePort("port", c.Port)
+}
+
+var cfg = config{
+	Port:   "8080",
+	Target: "World",
+}
+
+func main() {
+	if err := loadConfig(&cfg); err != nil {
+		log.Fatal(err)
 	}
 
-	log.Printf("Listening on localhost:%s", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), nil))
+	http.HandleFunc("/", handler)
+
+	log.Printf("Listening on localhost:%s", cfg.Port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", cfg.Port), nil))
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
 	log.Print("Hello world received a request.")
-	target := os.Getenv("TARGET")
-	if target == "" {
-		target = "World"
-	}
-	fmt.Fprintf(w, "Hello %s!\n", target)
+	fmt.Fprintf(w, "Hello %s!\n", cfg.Target)
 }